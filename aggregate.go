@@ -0,0 +1,400 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var aggregateFuncs = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+func isAggregate(e Expr) bool {
+	call, ok := e.(*FuncCall)
+	return ok && aggregateFuncs[call.Name]
+}
+
+func hasAggregate(e Expr) (found bool) {
+	walkExpr(e, func(e Expr) {
+		found = found || isAggregate(e)
+	})
+	return
+}
+
+// isAggregate reports whether the query returns one row per group instead of one row per table row
+func (ast *SelectAST) isAggregate() bool {
+	if len(ast.GroupBy) != 0 || ast.Having != nil {
+		return true
+	}
+	for _, field := range ast.Fields {
+		if hasAggregate(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggState accumulates the values of one aggregate function in one group
+type aggState interface {
+	add(v interface{}) error
+	result() interface{}
+}
+
+func newAggState(call *FuncCall) aggState {
+	switch call.Name {
+	case "COUNT":
+		return &countState{star: call.Star}
+	case "SUM":
+		return &sumState{}
+	case "AVG":
+		return &avgState{}
+	case "MIN":
+		return &extremeState{want: -1}
+	default:
+		return &extremeState{want: 1}
+	}
+}
+
+type countState struct {
+	star  bool
+	count int
+}
+
+func (s *countState) add(v interface{}) error {
+	if s.star || v != nil {
+		s.count++
+	}
+	return nil
+}
+
+func (s *countState) result() interface{} { return s.count }
+
+// sumState sums int as int and switches to float64 at the first float64, SUM of no rows is NULL
+type sumState struct {
+	sum interface{}
+}
+
+func (s *sumState) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if _, ok := toFloat(v); !ok {
+		return fmt.Errorf("can not SUM %T", v)
+	}
+	if s.sum == nil {
+		s.sum = v
+		return nil
+	}
+	sum, err := arithmetic("+", s.sum, v)
+	s.sum = sum
+	return err
+}
+
+func (s *sumState) result() interface{} { return s.sum }
+
+// avgState is float64, except that the average of decimals is a decimal
+type avgState struct {
+	sum   sumState
+	count int
+}
+
+func (s *avgState) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if _, ok := toFloat(v); !ok {
+		return fmt.Errorf("can not AVG %T", v)
+	}
+	s.count++
+	return s.sum.add(v)
+}
+
+func (s *avgState) result() interface{} {
+	if s.count == 0 {
+		return nil
+	}
+	if sum, ok := s.sum.sum.(Decimal); ok {
+		avg, _ := decimalArithmetic("/", sum, s.count)
+		return avg
+	}
+	sum, _ := toFloat(s.sum.sum)
+	return sum / float64(s.count)
+}
+
+// extremeState keeps the value v for which compareValue(v, others) is want, MIN is -1 and MAX is 1
+type extremeState struct {
+	want int
+	val  interface{}
+}
+
+func (s *extremeState) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if s.val == nil {
+		s.val = v
+		return nil
+	}
+	c, ok := compareValue(v, s.val)
+	if !ok {
+		return fmt.Errorf("can not compare %T with %T", v, s.val)
+	}
+	if c == s.want {
+		s.val = v
+	}
+	return nil
+}
+
+func (s *extremeState) result() interface{} { return s.val }
+
+type aggGroup struct {
+	keys   []interface{}
+	states []aggState
+}
+
+/*
+hashAggregate groups the rows by the GROUP BY values in a hash table.
+
+Every group becomes a row of the GROUP BY values followed by the results of
+the aggregate functions. The projections, HAVING and ORDER BY are rewritten to
+read this row: a GROUP BY expression or an aggregate call becomes the column
+"#n" of the group row, any other column is an error.
+*/
+type hashAggregate struct {
+	input   columnResolver // resolves the columns of the input rows
+	groupBy []evalFunc
+	keys    []string // exprKey of the GROUP BY expressions
+	calls   []*FuncCall
+	args    []evalFunc // argument of the calls, nil for COUNT(*)
+
+	groups map[string]*aggGroup
+	order  []*aggGroup // groups in the order they are first seen
+}
+
+func newHashAggregate(input columnResolver, groupBy []Expr) (*hashAggregate, error) {
+	a := &hashAggregate{input: input, groups: make(map[string]*aggGroup)}
+	for _, e := range groupBy {
+		if hasAggregate(e) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+		eval, err := compileExpr(e, input)
+		if err != nil {
+			return nil, err
+		}
+		a.groupBy = append(a.groupBy, eval)
+		a.keys = append(a.keys, a.exprKey(e))
+	}
+	return a, nil
+}
+
+// exprKey identifies an expression, so that user.id and id are the same GROUP BY column
+func (a *hashAggregate) exprKey(e Expr) string {
+	if ref, ok := e.(*ColumnRef); ok {
+		if idx, err := a.input(ref); err == nil {
+			return "#" + strconv.Itoa(idx)
+		}
+	}
+	return e.String()
+}
+
+func groupColumn(idx int) *ColumnRef {
+	return &ColumnRef{Name: "#" + strconv.Itoa(idx)}
+}
+
+// rewrite makes e read the group row, the aggregate calls in e are added to a
+func (a *hashAggregate) rewrite(e Expr) (Expr, error) {
+	e, err := rewriteExpr(e, func(e Expr) (Expr, error) {
+		key := a.exprKey(e)
+		for i, groupKey := range a.keys {
+			if key == groupKey {
+				return groupColumn(i), nil
+			}
+		}
+		call, ok := e.(*FuncCall)
+		if !ok || !aggregateFuncs[call.Name] {
+			return nil, nil
+		}
+
+		for i, c := range a.calls {
+			if c.String() == call.String() {
+				return groupColumn(len(a.groupBy) + i), nil
+			}
+		}
+		if !call.Star && len(call.Args) != 1 || call.Star && call.Name != "COUNT" {
+			return nil, fmt.Errorf("wrong number of arguments to %s", call.Name)
+		}
+		var arg evalFunc
+		if !call.Star {
+			if hasAggregate(call.Args[0]) {
+				return nil, fmt.Errorf("aggregate functions can not be nested: %s", call)
+			}
+			var err error
+			if arg, err = compileExpr(call.Args[0], a.input); err != nil {
+				return nil, err
+			}
+		}
+		a.calls = append(a.calls, call)
+		a.args = append(a.args, arg)
+		return groupColumn(len(a.groupBy) + len(a.calls) - 1), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	walkExpr(e, func(e Expr) {
+		if ref, ok := e.(*ColumnRef); ok && !strings.HasPrefix(ref.Name, "#") && err == nil {
+			err = fmt.Errorf("column %s must appear in GROUP BY or be used in an aggregate function", ref)
+		}
+	})
+	return e, err
+}
+
+// resolve resolves the columns of the rewritten expressions
+func (a *hashAggregate) resolve(col *ColumnRef) (int, error) {
+	if idx, err := strconv.Atoi(strings.TrimPrefix(col.Name, "#")); err == nil && col.Table == "" {
+		return idx, nil
+	}
+	return -1, HasNotColumnError
+}
+
+// add puts a table row into its group
+func (a *hashAggregate) add(row []interface{}) error {
+	keys := make([]interface{}, len(a.groupBy))
+	var hashKey []byte
+	for i, eval := range a.groupBy {
+		v, err := eval(row)
+		if err != nil {
+			return err
+		}
+		keys[i] = v
+		if hashKey, err = appendValue(hashKey, v); err != nil {
+			return err
+		}
+	}
+
+	group := a.groups[string(hashKey)]
+	if group == nil {
+		group = a.newGroup(keys)
+		a.groups[string(hashKey)] = group
+	}
+
+	for i, state := range group.states {
+		var v interface{}
+		if a.args[i] != nil {
+			var err error
+			if v, err = a.args[i](row); err != nil {
+				return err
+			}
+		}
+		if err := state.add(v); err != nil {
+			return fmt.Errorf("%s: %s", a.calls[i], err)
+		}
+	}
+	return nil
+}
+
+func (a *hashAggregate) newGroup(keys []interface{}) *aggGroup {
+	group := &aggGroup{keys: keys, states: make([]aggState, len(a.calls))}
+	for i, call := range a.calls {
+		group.states[i] = newAggState(call)
+	}
+	a.order = append(a.order, group)
+	return group
+}
+
+// rows returns the group rows, without GROUP BY there is one group even if there are no rows
+func (a *hashAggregate) rows() []*BPItem {
+	if len(a.groupBy) == 0 && len(a.order) == 0 {
+		a.newGroup(nil)
+	}
+	rows := make([]*BPItem, 0, len(a.order))
+	for i, group := range a.order {
+		val := append([]interface{}{}, group.keys...)
+		for _, state := range group.states {
+			val = append(val, state.result())
+		}
+		rows = append(rows, &BPItem{Key: int64(i), Val: val})
+	}
+	return rows
+}
+
+// aggregateOp reads all its input on Open and returns a row per group
+type aggregateOp struct {
+	input Operator
+	agg   *hashAggregate
+	rows  []*BPItem
+}
+
+func (a *aggregateOp) Open() error {
+	if err := a.input.Open(); err != nil {
+		return err
+	}
+	for {
+		row, err := a.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if err := a.agg.add(row.Val.([]interface{})); err != nil {
+			return err
+		}
+	}
+	a.rows = a.agg.rows()
+	return nil
+}
+
+func (a *aggregateOp) Next() (*BPItem, error) {
+	if len(a.rows) == 0 {
+		return nil, nil
+	}
+	row := a.rows[0]
+	a.rows = a.rows[1:]
+	return row, nil
+}
+
+func (a *aggregateOp) Close() error { return a.input.Close() }
+
+// aggregate adds the aggregate and HAVING operators on top of input, the projections
+// and the ORDER BY terms are returned rewritten to read the group rows which are resolved by resolve
+func (p *Plan) aggregate(ast *SelectAST, terms []*OrderByTerm, input Operator, inputResolve columnResolver) (
+	op Operator, fields []Expr, orderBy []*OrderByTerm, resolve columnResolver, err error) {
+	agg, err := newHashAggregate(inputResolve, ast.GroupBy)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// rewrite everything evaluated after grouping before the first row is added,
+	// so that every group gets the state of every aggregate call
+	fields = make([]Expr, len(ast.Fields))
+	for i, field := range ast.Fields {
+		if field == nil {
+			return nil, nil, nil, nil, fmt.Errorf("can not select * with aggregate functions or GROUP BY")
+		}
+		if fields[i], err = agg.rewrite(field); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	orderBy = make([]*OrderByTerm, len(terms))
+	for i, term := range terms {
+		e, err := agg.rewrite(term.Expr)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		orderBy[i] = &OrderByTerm{Expr: e, Desc: term.Desc}
+	}
+
+	op = &aggregateOp{input: input, agg: agg}
+	if ast.Having != nil {
+		having, err := agg.rewrite(ast.Having)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		filter, err := compileFilter(having, agg.resolve)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		op = &filterOp{input: op, filter: filter}
+	}
+	return op, fields, orderBy, agg.resolve, nil
+}
@@ -0,0 +1,263 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	ColumnExistError    = fmt.Errorf("column already exists")
+	DropPrimaryKeyError = fmt.Errorf("can not drop the primary key")
+	DropIndexedError    = fmt.Errorf("can not drop an indexed column, drop the index first")
+)
+
+/*
+ALTER TABLE never changes a table in place: it builds a new Table and
+swaps it into DB.Tables, the undo swaps the old one back. The rows are
+rewritten into a new clustered tree when the columns change.
+*/
+
+func (db *DB) AlterTable(parser *Parser, sql string) error {
+	ast, err := parser.ParseAlterTable(sql)
+	if err != nil {
+		return err
+	}
+	return db.alterTable(ast, sql)
+}
+
+func (db *DB) alterTable(ast *AlterTableAST, sql string) error {
+	old := db.GetTable(ast.Table)
+	if old == nil {
+		return fmt.Errorf("has no such table: %s", ast.Table)
+	}
+
+	var table *Table
+	var err error
+	switch ast.Action {
+	case AddColumn:
+		table, err = db.addColumn(old, ast)
+	case DropColumn:
+		table, err = old.dropColumn(ast.Column)
+	case RenameColumn:
+		table, err = old.renameColumn(ast.Column, ast.NewName)
+	case RenameTable:
+		if other := db.GetTable(ast.NewName); other != nil && other != old {
+			err = TableExistError
+		} else {
+			table = old.renameTable(ast.NewName)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("alter table %s err: %s", ast.Table, err)
+	}
+
+	undo := func() {
+		db.removeTable(table.Name)
+		db.AddTable(old)
+	}
+	if journal := db.journal(); journal != nil {
+		if err := journal.ddl(sql, undo); err != nil {
+			return err
+		}
+	}
+	if ast.Action == AddColumn && db.wal != nil {
+		if err := db.logAddedColumn(table); err != nil {
+			return err
+		}
+	}
+	db.removeTable(old.Name)
+	db.AddTable(table)
+	return nil
+}
+
+// clone returns a copy of the table sharing the trees
+func (t *Table) clone() *Table {
+	c := *t
+	c.Columns = append([]string{}, t.Columns...)
+	c.DefaultValue = append([]interface{}{}, t.DefaultValue...)
+	c.Constraint = make(map[string]func(v interface{}) error, len(t.Constraint))
+	for col, fn := range t.Constraint {
+		c.Constraint[col] = fn
+	}
+	c.Formatter = make(map[string]func(v interface{}) interface{}, len(t.Formatter))
+	for col, fn := range t.Formatter {
+		c.Formatter[col] = fn
+	}
+	c.Indies = make(map[string]*BPTree, len(t.Indies))
+	for name, tree := range t.Indies {
+		c.Indies[name] = tree
+	}
+	c.IndexSchema = make(map[string]*CreateIndexAST, len(t.IndexSchema))
+	for name, ast := range t.IndexSchema {
+		index := *ast
+		c.IndexSchema[name] = &index
+	}
+	if t.Schema != nil {
+		schema := *t.Schema
+		schema.Columns = append([]string{}, t.Schema.Columns...)
+		schema.Type = append([]string{}, t.Schema.Type...)
+		schema.NotNull = append([]bool{}, t.Schema.NotNull...)
+		schema.Default = append([]string{}, t.Schema.Default...)
+		schema.PrimaryKey = append([]string{}, t.Schema.PrimaryKey...)
+		c.Schema = &schema
+	}
+	return &c
+}
+
+// rewriteRows fills a new clustered tree with fn(row) of every row and rebuilds the secondary indexes
+func (t *Table) rewriteRows(fn func(row []interface{}) []interface{}) error {
+	old := t.GetClusterIndex()
+	tree := NewBPTree(old.width, nil)
+	for item := range old.GetAllItems() {
+		tree.Set(item.Key, fn(item.Val.([]interface{})))
+	}
+
+	indexes := t.IndexSchema
+	t.Indies = map[string]*BPTree{"-": tree}
+	t.IndexSchema = nil
+	if err := t.buildPrimaryIndex(); err != nil {
+		return err
+	}
+	for _, ast := range indexes {
+		if err := t.CreateIndex(ast); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn appends the column of ast, the rows get its default value
+func (db *DB) addColumn(old *Table, ast *AlterTableAST) (*Table, error) {
+	if old.columnIdx(ast.Column) != -1 {
+		return nil, ColumnExistError
+	}
+	def, err := db.NewTable(&CreateTableAST{
+		Table:   old.Name,
+		Columns: []string{ast.Column},
+		Type:    []string{ast.Type},
+		NotNull: []bool{ast.NotNull},
+		Default: []string{ast.Default},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the rows get the default, so a NOT NULL column needs one
+	value := def.defaultValue(0)
+	if check := def.Constraint[ast.Column]; check != nil {
+		if err := check(value); err != nil {
+			return nil, err
+		}
+	}
+
+	c := old.clone()
+	c.Columns = append(c.Columns, ast.Column)
+	c.DefaultValue = append(c.DefaultValue, def.DefaultValue[0])
+	c.Formatter[ast.Column] = def.Formatter[ast.Column]
+	c.Constraint[ast.Column] = def.Constraint[ast.Column]
+	if c.Schema != nil {
+		c.Schema.Columns = append(c.Schema.Columns, ast.Column)
+		c.Schema.Type = append(c.Schema.Type, ast.Type)
+		c.Schema.NotNull = append(c.Schema.NotNull, ast.NotNull)
+		c.Schema.Default = append(c.Schema.Default, ast.Default)
+	}
+
+	err = c.rewriteRows(func(row []interface{}) []interface{} {
+		return append(append(make([]interface{}, 0, len(row)+1), row...), value)
+	})
+	return c, err
+}
+
+// logAddedColumn logs the rows after ADD COLUMN when its DEFAULT is
+// CURRENT_TIMESTAMP, the replay of the statement would give them another time.
+// The rows are not undone one by one, the undo of the statement drops the table.
+func (db *DB) logAddedColumn(table *Table) error {
+	if _, ok := table.DefaultValue[len(table.DefaultValue)-1].(currentTime); !ok {
+		return nil
+	}
+	for item := range table.GetClusterIndex().GetAllItems() {
+		if err := db.wal.put(table, item.Key, item.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) dropColumn(col string) (*Table, error) {
+	idx := t.columnIdx(col)
+	if idx == -1 {
+		return nil, HasNotColumnError
+	}
+	col = t.Columns[idx] // as in CREATE TABLE
+	if containsColumn(t.primaryKey(), col) {
+		return nil, DropPrimaryKeyError
+	}
+	for _, ast := range t.IndexSchema {
+		if strings.EqualFold(ast.Column, col) {
+			return nil, DropIndexedError
+		}
+	}
+
+	c := t.clone()
+	c.Columns = append(c.Columns[:idx], c.Columns[idx+1:]...)
+	c.DefaultValue = append(c.DefaultValue[:idx], c.DefaultValue[idx+1:]...)
+	delete(c.Formatter, col)
+	delete(c.Constraint, col)
+	if s := c.Schema; s != nil {
+		s.Columns = append(s.Columns[:idx], s.Columns[idx+1:]...)
+		s.Type = append(s.Type[:idx], s.Type[idx+1:]...)
+		s.NotNull = append(s.NotNull[:idx], s.NotNull[idx+1:]...)
+		s.Default = append(s.Default[:idx], s.Default[idx+1:]...)
+	}
+
+	err := c.rewriteRows(func(row []interface{}) []interface{} {
+		return append(append(make([]interface{}, 0, len(row)-1), row[:idx]...), row[idx+1:]...)
+	})
+	return c, err
+}
+
+// renameColumn only renames, the rows are kept as they are
+func (t *Table) renameColumn(col, newName string) (*Table, error) {
+	idx := t.columnIdx(col)
+	if idx == -1 {
+		return nil, HasNotColumnError
+	}
+	if t.columnIdx(newName) != -1 {
+		return nil, ColumnExistError
+	}
+	col = t.Columns[idx] // as in CREATE TABLE
+
+	c := t.clone()
+	c.Columns[idx] = newName
+	c.Formatter[newName], c.Constraint[newName] = c.Formatter[col], c.Constraint[col]
+	delete(c.Formatter, col)
+	delete(c.Constraint, col)
+	if c.PrimaryKey == col {
+		c.PrimaryKey = newName
+	}
+	for _, ast := range c.IndexSchema {
+		if strings.EqualFold(ast.Column, col) {
+			ast.Column = strings.ToLower(newName)
+		}
+	}
+	if c.Schema != nil {
+		c.Schema.Columns[idx] = newName
+		for i, key := range c.Schema.PrimaryKey {
+			if key == col {
+				c.Schema.PrimaryKey[i] = newName
+			}
+		}
+	}
+	return c, nil
+}
+
+func (t *Table) renameTable(newName string) *Table {
+	c := t.clone()
+	c.Name = newName
+	for _, ast := range c.IndexSchema {
+		ast.Table = newName
+	}
+	if c.Schema != nil {
+		c.Schema.Table = newName
+	}
+	return c
+}
@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"reflect"
 	"sync"
 )
@@ -159,7 +160,7 @@ func (node *BPNode) popLastItem() *BPItem {
 	last := len(node.Items) - 1
 	item := node.Items[last]
 	node.Items = node.Items[:last]
-	node.MaxKey = node.Items[last].Key
+	node.MaxKey = node.Items[last-1].Key
 	return item
 }
 
@@ -174,7 +175,7 @@ func (node *BPNode) popLastChild() *BPNode {
 	last := len(node.Children) - 1
 	child := node.Children[last]
 	node.Children = node.Children[:last]
-	node.MaxKey = node.Children[last].MaxKey
+	node.MaxKey = node.Children[last-1].MaxKey
 	return child
 }
 
@@ -213,9 +214,15 @@ func (node *BPNode) addChildren(children []*BPNode) {
 }
 
 func (node *BPNode) deleteChild(child *BPNode) bool {
-	idx, exist := node.findChild(child.MaxKey)
-	child = nil
-	if !exist {
+	// 合并后兄弟结点的maxKey可能相同,不能按maxKey查找
+	idx := -1
+	for i, c := range node.Children {
+		if c == child {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
 		return false
 	}
 	copy(node.Children[idx:], node.Children[idx+1:])
@@ -232,8 +239,9 @@ func (node *BPNode) deleteChild(child *BPNode) bool {
 type BPTree struct {
 	mutex     sync.RWMutex
 	root      *BPNode
-	width     int // B+树的阶
-	halfWidth int // ceil(M/2)
+	width     int    // B+树的阶
+	halfWidth int    // ceil(M/2)
+	version   uint64 // 每次修改加一, 用于Cursor判断树是否变化
 
 	genNodeID func() int
 }
@@ -245,6 +253,13 @@ func defaultNewID() int {
 	return count
 }
 
+// reserveNodeID keeps defaultNewID from handing out an ID loaded from disk
+func reserveNodeID(id int) {
+	if id > count {
+		count = id
+	}
+}
+
 func NewBPTree(width int, getNewID func() int) *BPTree {
 	if width < 3 {
 		width = 3
@@ -310,29 +325,99 @@ func (t *BPTree) getFarLeftLeaf(node *BPNode) *BPNode {
 	return t.getFarLeftLeaf(child)
 }
 
+// GetAllItems returns all items in key order. The items are collected under the lock
+// and the returned channel is closed, so the consumer may stop reading at any time.
 func (t *BPTree) GetAllItems() chan *BPItem {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var items []*BPItem
+	for node := t.getFarLeftLeaf(t.root); node != nil; node = node.Next {
+		items = append(items, node.Items...)
+	}
 
-	ch := make(chan *BPItem, 512)
+	ch := make(chan *BPItem, len(items))
+	for _, item := range items {
+		ch <- item
+	}
+	close(ch)
+	return ch
+}
 
+// Scan sends all items in key order without collecting them first.
+// The channel is closed when all items are sent or ctx is done,
+// cancel ctx to stop a consumer which does not read to the end.
+func (t *BPTree) Scan(ctx context.Context) <-chan *BPItem {
+	ch := make(chan *BPItem)
 	go func() {
-		node := t.getFarLeftLeaf(t.root)
-		for {
-			if node == nil {
-				break
+		defer close(ch)
+		c := t.NewCursor(false)
+		defer c.Close()
+		for ok := c.First(); ok; ok = c.Next() {
+			select {
+			case ch <- &BPItem{Key: c.Key(), Val: c.Value()}:
+			case <-ctx.Done():
+				return
 			}
+		}
+	}()
+	return ch
+}
 
-			for _, item := range node.Items {
-				ch <- item
-			}
+// firstGE returns the leaf and item index of the smallest key >= key
+func (node *BPNode) firstGE(key int64) (*BPNode, int) {
+	for !node.IsLeaf() {
+		idx, _ := node.findChild(key)
+		if idx == len(node.Children) {
+			return nil, 0
+		}
+		node = node.Children[idx]
+	}
+	idx, _ := node.findItem(key)
+	for node != nil && idx >= len(node.Items) {
+		node, idx = node.Next, 0
+	}
+	return node, idx
+}
 
-			node = node.Next
+// lastLE returns the leaf and item index of the largest key <= key
+func (node *BPNode) lastLE(key int64) (*BPNode, int) {
+	if node.IsLeaf() {
+		idx, exist := node.findItem(key)
+		if !exist {
+			idx--
 		}
-		close(ch)
-	}()
+		if idx < 0 {
+			return nil, 0
+		}
+		return node, idx
+	}
+	idx, _ := node.findChild(key)
+	if idx == len(node.Children) {
+		idx-- // all keys are smaller
+	}
+	for ; idx >= 0; idx-- {
+		if leaf, i := node.Children[idx].lastLE(key); leaf != nil {
+			return leaf, i
+		}
+	}
+	return nil, 0
+}
 
-	return ch
+// rangeItems calls fn for items with lo <= key <= hi in order, until fn returns false
+func (t *BPTree) rangeItems(lo, hi int64, fn func(item *BPItem) bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	node, idx := t.root.firstGE(lo)
+	for ; node != nil; node, idx = node.Next, 0 {
+		for ; idx < len(node.Items); idx++ {
+			item := node.Items[idx]
+			if item.Key > hi || !fn(item) {
+				return
+			}
+		}
+	}
 }
 
 func (t *BPTree) GetData() map[int64]interface{} {
@@ -386,6 +471,7 @@ func (t *BPTree) splitNode(node *BPNode) (newNode *BPNode) {
 		newNode.addItem(node.Items[halfW:len(node.Items)]...)
 
 		//修改原结点数据
+		newNode.Next = node.Next
 		node.Next = newNode
 		node.Items = node.Items[0:halfW]
 		node.MaxKey = node.Items[len(node.Items)-1].Key
@@ -399,6 +485,7 @@ func (t *BPTree) splitNode(node *BPNode) (newNode *BPNode) {
 func (t *BPTree) Set(key int64, value interface{}) (update bool) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	t.version++
 	update = t.setValue(nil, t.root, key, value)
 	return
 }
@@ -438,6 +525,7 @@ func (t *BPTree) Remove(key int64) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	t.version++
 	t.deleteItem(nil, t.root, key)
 }
 
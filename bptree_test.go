@@ -1,10 +1,14 @@
 package sqlite
 
 import (
+	"context"
 	"encoding/json"
 	"math/rand"
 	"reflect"
+	"runtime"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestBPT(t *testing.T) {
@@ -281,3 +285,188 @@ func TestMultiInsertAndDelete(t *testing.T) {
 		t.Errorf("returned struct after delete \n")
 	}
 }
+
+// checkKeys checks the leaf chain and the max keys against the keys left in the tree
+func checkKeys(t *testing.T, tree *BPTree, keys map[int64]bool) {
+	t.Helper()
+	var want []int64
+	for key := range keys {
+		want = append(want, key)
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+
+	var got []int64
+	for leaf := tree.GetFarLeftLeaf(); leaf != nil; leaf = leaf.Next {
+		for _, item := range leaf.Items {
+			got = append(got, item.Key)
+		}
+	}
+	if len(got) != len(want) || len(want) != 0 && !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected keys %v and got %v", want, got)
+	}
+	if len(want) != 0 && tree.root.MaxKey != want[len(want)-1] {
+		t.Fatalf("expected max key %d and got %d", want[len(want)-1], tree.root.MaxKey)
+	}
+}
+
+func TestSplitKeepsLeafChain(t *testing.T) {
+	for _, width := range []int{3, 4, 5} {
+		// interleaved keys split leaves in the middle of the chain
+		tree := NewBPTree(width, nil)
+		keys := make(map[int64]bool)
+		for i := int64(0); i < 50; i++ {
+			for step := int64(0); step < 5; step++ {
+				key := step*50 + i + 1
+				tree.Set(key, key)
+				keys[key] = true
+				checkKeys(t, tree, keys)
+			}
+		}
+	}
+}
+
+func TestRemoveBorrowAndMerge(t *testing.T) {
+	for _, width := range []int{3, 4, 5} {
+		// removing from the end borrows from the left sibling, from the front borrows from the right one
+		for _, order := range []string{"desc", "asc", "rand"} {
+			tree := NewBPTree(width, nil)
+			keys := make(map[int64]bool)
+			var removes []int64
+			for key := int64(1); key <= 300; key++ {
+				tree.Set(key, key)
+				keys[key] = true
+				removes = append(removes, key)
+			}
+			switch order {
+			case "desc":
+				sort.Slice(removes, func(i, j int) bool { return removes[i] > removes[j] })
+			case "rand":
+				rand.New(rand.NewSource(int64(width))).Shuffle(len(removes), func(i, j int) {
+					removes[i], removes[j] = removes[j], removes[i]
+				})
+			}
+
+			for idx, key := range removes {
+				tree.Remove(key)
+				delete(keys, key)
+				if tree.Get(key) != nil {
+					t.Fatalf("width %d %s: %d is found after delete", width, order, key)
+				}
+				if idx%10 == 0 || len(keys) < 20 {
+					checkKeys(t, tree, keys)
+				}
+			}
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tree := NewBPTree(4, nil)
+	for key := 1; key != 1000; key++ {
+		tree.Set(int64(key*2), key) // even keys only
+	}
+
+	c := tree.NewCursor(false)
+	defer c.Close()
+
+	if !c.SeekTo(501) || c.Key() != 502 || c.Value() != 251 {
+		t.Fatalf("seek err")
+	}
+	if !c.Next() || c.Key() != 504 {
+		t.Errorf("next err")
+	}
+	if !c.Prev() || !c.Prev() || c.Key() != 500 {
+		t.Errorf("prev err")
+	}
+
+	// paginate [100, 200) by 7
+	var keys []int64
+	for lo := int64(100); ; {
+		var page int
+		for ok := c.SeekTo(lo); ok && c.Key() < 200 && page < 7; ok = c.Next() {
+			keys = append(keys, c.Key())
+			page++
+		}
+		if page < 7 {
+			break
+		}
+		lo = keys[len(keys)-1] + 1
+	}
+	if len(keys) != 50 || keys[0] != 100 || keys[49] != 198 {
+		t.Errorf("paginate err: %v", keys)
+	}
+
+	reverse := tree.NewCursor(true)
+	if !reverse.SeekTo(501) || reverse.Key() != 500 {
+		t.Errorf("reverse seek err")
+	}
+	var n int
+	for ok := reverse.First(); ok; ok = reverse.Next() {
+		if want := int64(1998 - 2*n); reverse.Key() != want {
+			t.Fatalf("expect %d and got %d", want, reverse.Key())
+		}
+		n++
+	}
+	if n != 999 {
+		t.Errorf("expect 999 keys and got %d", n)
+	}
+	if reverse.SeekTo(1) || reverse.Prev() {
+		t.Errorf("expect no key <= 1")
+	}
+
+	// the tree is modified while the cursor is open
+	n = 0
+	for ok := c.First(); ok; ok = c.Next() {
+		if key := c.Key(); key%2 == 0 {
+			tree.Remove(key)
+			tree.Set(key+1, 0)
+		}
+		n++
+	}
+	if n != 1998 {
+		t.Errorf("expect to visit 1998 keys and got %d", n)
+	}
+	for ok := c.First(); ok; ok = c.Next() {
+		if c.Key()%2 != 1 {
+			t.Fatalf("unexpected key %d", c.Key())
+		}
+	}
+
+	c.Close()
+	if c.First() {
+		t.Errorf("closed cursor moved")
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	tree := NewBPTree(4, nil)
+	for key := 1; key != 1000; key++ {
+		tree.Set(int64(key), key)
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		for item := range tree.Scan(ctx) {
+			if item.Key == 10 {
+				break
+			}
+		}
+		cancel()
+	}
+	key := int64(0)
+	for item := range tree.Scan(context.Background()) {
+		key++
+		if item.Key != key {
+			t.Fatalf("expect %d and got %d", key, item.Key)
+		}
+	}
+	if key != 999 {
+		t.Errorf("expect 999 items and got %d", key)
+	}
+
+	time.Sleep(10 * time.Millisecond) // let the cancelled producers exit
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("goroutines grow from %d to %d", before, after)
+	}
+}
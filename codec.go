@@ -0,0 +1,198 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// value tags of the on-disk encoding
+const (
+	tagNil byte = iota
+	tagInt
+	tagString
+	tagBool
+	tagBytes
+	tagFloat
+	tagList
+	tagTime
+	tagDecimal
+)
+
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case nil:
+		buf = append(buf, tagNil)
+	case int:
+		buf = append(buf, tagInt)
+		buf = binary.AppendVarint(buf, int64(val))
+	case int64:
+		buf = append(buf, tagInt)
+		buf = binary.AppendVarint(buf, val)
+	case string:
+		buf = append(buf, tagString)
+		buf = appendBytes(buf, []byte(val))
+	case bool:
+		buf = append(buf, tagBool)
+		if val {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case []byte:
+		buf = append(buf, tagBytes)
+		buf = appendBytes(buf, val)
+	case float64:
+		buf = append(buf, tagFloat)
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(val))
+	case time.Time:
+		buf = append(buf, tagTime)
+		buf = binary.AppendVarint(buf, val.Unix())
+		buf = binary.AppendUvarint(buf, uint64(val.Nanosecond()))
+	case Decimal:
+		buf = append(buf, tagDecimal)
+		buf = appendBytes(buf, []byte(val.String()))
+	case []interface{}:
+		buf = append(buf, tagList)
+		buf = binary.AppendUvarint(buf, uint64(len(val)))
+		for _, item := range val {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("can not encode value of type %T", v)
+	}
+	return buf, nil
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads values written by appendValue
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("bad uvarint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count reads the number of elements which follow, each takes one byte at least,
+// so a count beyond the data left fails before anything is allocated for it
+func (d *decoder) count() uint64 {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.buf)) {
+		d.fail("count %d exceeds the %d bytes left", n, len(d.buf))
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.buf[:n])
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) value() interface{} {
+	switch tag := d.byte(); tag {
+	case tagNil:
+		return nil
+	case tagInt:
+		return int(d.varint())
+	case tagString:
+		return d.string()
+	case tagBool:
+		return d.byte() == 1
+	case tagBytes:
+		return d.bytes()
+	case tagFloat:
+		if len(d.buf) < 8 {
+			d.fail("unexpected end of data")
+			return nil
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return v
+	case tagTime:
+		sec := d.varint()
+		return time.Unix(sec, int64(d.uvarint())).UTC()
+	case tagDecimal:
+		s := d.string()
+		v, err := ParseDecimal(s)
+		if err != nil {
+			d.fail("bad decimal %q", s)
+		}
+		return v
+	case tagList:
+		n := d.count()
+		list := make([]interface{}, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			list = append(list, d.value())
+		}
+		return list
+	default:
+		d.fail("unknown value tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"sync"
+)
+
+/*
+Conn is a connection which runs BEGIN, COMMIT and ROLLBACK as statements:
+
+	conn := db.Conn()
+	defer conn.Close()
+	err = conn.Exec(`BEGIN`)
+	err = conn.Exec(`INSERT INTO user (id, username) VALUES (1, "a")`)
+	err = conn.Exec(`COMMIT`)
+
+The transaction started by BEGIN belongs to the connection, only its
+statements run in it. DB.Exec, DB.Query, Begin and the other connections
+wait for COMMIT or ROLLBACK as they wait for any Tx, so they never see its
+uncommitted rows. The statements of a connection run one at a time.
+*/
+type Conn struct {
+	db      *DB
+	lock    sync.Mutex // serializes the statements of the connection
+	session *Tx        // the transaction started by BEGIN, nil if there is none
+}
+
+func (db *DB) Conn() *Conn {
+	return &Conn{db: db}
+}
+
+// Exec runs a statement in the transaction of the connection, or in its own one
+func (c *Conn) Exec(sql string) error {
+	stmt, err := c.db.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch stmt.Type {
+	case BEGIN:
+		if c.session != nil {
+			return TxInProgressError
+		}
+		tx, err := c.db.Begin()
+		if err != nil {
+			return err
+		}
+		c.session = tx
+		return nil
+	case COMMIT, ROLLBACK:
+		if c.session == nil {
+			return NoTxError
+		}
+		session := c.session
+		c.session = nil // the transaction is finished even if COMMIT fails
+		_, err := session.run(stmt, nil)
+		return err
+	}
+
+	if c.session != nil {
+		_, err = c.session.exec(stmt, nil)
+	} else {
+		_, err = stmt.execute(nil)
+	}
+	return err
+}
+
+// Query runs a SELECT, in the transaction of the connection it sees its changes
+func (c *Conn) Query(sql string) (*Rows, error) {
+	stmt, err := c.db.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session != nil {
+		return c.session.query(stmt, nil)
+	}
+	return stmt.Query()
+}
+
+// Close rolls back the transaction left open by BEGIN
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session == nil {
+		return nil
+	}
+	session := c.session
+	c.session = nil
+	return session.Rollback()
+}
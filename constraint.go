@@ -2,30 +2,36 @@ package sqlite
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 )
 
 var (
-	IsNotInteger         = fmt.Errorf("is not inetger")
-	IsSignedIntegerError = fmt.Errorf("is not signed integer")
-	IsNotString          = fmt.Errorf("is not string")
-	IsNotBoolError       = fmt.Errorf("is not bool")
-	HasNoPrimaryKeyError = fmt.Errorf("has no primary key")
-	NotEmptyError        = fmt.Errorf("not empty")
-	VarCharTooLongError  = fmt.Errorf("varchar too long")
-	OptionLimitError     = fmt.Errorf("option limit error")
+	IsNotInteger           = fmt.Errorf("is not inetger")
+	IsSignedIntegerError   = fmt.Errorf("is not signed integer")
+	IsNotString            = fmt.Errorf("is not string")
+	IsNotRealError         = fmt.Errorf("is not real")
+	IsNotBlobError         = fmt.Errorf("is not blob")
+	IsNotTimeError         = fmt.Errorf("is not an ISO-8601 date or time")
+	IsNotDecimalError      = fmt.Errorf("is not decimal")
+	IsNotBoolError         = fmt.Errorf("is not bool")
+	HasNoPrimaryKeyError   = fmt.Errorf("has no primary key")
+	NotEmptyError          = fmt.Errorf("not empty")
+	NotNullError           = fmt.Errorf("NOT NULL constraint failed")
+	VarCharTooLongError    = fmt.Errorf("varchar too long")
+	DecimalOutOfRangeError = fmt.Errorf("decimal out of range")
+	OptionLimitError       = fmt.Errorf("option limit error")
 
 	DuplicateKeyError = fmt.Errorf("duplicate key")
 	HasNotColumnError = fmt.Errorf("has no such column")
 	TableError        = fmt.Errorf("has no such table")
+	TableExistError   = fmt.Errorf("table already exists")
 	SyntaxError       = fmt.Errorf("syntax error")
 )
 
-func Compose(fns ...func(data string) error) func(data string) error {
-	return func(data string) error {
+// Compose checks a value by all fns, the values are typed: int, float64, string, bool or nil for NULL
+func Compose(fns ...func(v interface{}) error) func(v interface{}) error {
+	return func(v interface{}) error {
 		for _, fn := range fns {
-			if err := fn(data); err != nil {
+			if err := fn(v); err != nil {
 				return err
 			}
 		}
@@ -33,24 +39,40 @@ func Compose(fns ...func(data string) error) func(data string) error {
 	}
 }
 
-func NotEmpty(data string) error {
-	if data == `""` {
+func NotNull(v interface{}) error {
+	if v == nil {
+		return NotNullError
+	}
+	return nil
+}
+
+// Nullable checks a value by fn unless it is NULL
+func Nullable(fn func(v interface{}) error) func(v interface{}) error {
+	return func(v interface{}) error {
+		if v == nil {
+			return nil
+		}
+		return fn(v)
+	}
+}
+
+func NotEmpty(v interface{}) error {
+	if v == nil || v == "" {
 		return NotEmptyError
 	}
 	return nil
 }
 
-func IsInteger(data string) error {
-	_, err := strconv.Atoi(data)
-	if err != nil {
+func IsInteger(v interface{}) error {
+	if _, ok := v.(int); !ok {
 		return IsNotInteger
 	}
 	return nil
 }
 
-func IsSignedInteger(data string) error {
-	d, err := strconv.Atoi(data)
-	if err != nil {
+func IsSignedInteger(v interface{}) error {
+	d, ok := v.(int)
+	if !ok {
 		return IsNotInteger
 	}
 	if d < 0 {
@@ -59,33 +81,68 @@ func IsSignedInteger(data string) error {
 	return nil
 }
 
-func IsString(data string) error {
-	if !strings.HasPrefix(data, "\"") {
-		return IsNotString
+// IsString accepts a []byte too, it is stored as string
+func IsString(v interface{}) error {
+	switch v.(type) {
+	case string, []byte:
+		return nil
 	}
-	if !strings.HasSuffix(data, "\"") {
-		return IsNotString
+	return IsNotString
+}
+
+// IsReal accepts an int too, it is stored as float64
+func IsReal(v interface{}) error {
+	switch v.(type) {
+	case int, float64:
+		return nil
 	}
-	return nil
+	return IsNotRealError
+}
+
+// IsBlob accepts a string too, it is stored as []byte
+func IsBlob(v interface{}) error {
+	switch v.(type) {
+	case []byte, string:
+		return nil
+	}
+	return IsNotBlobError
 }
 
-func IsBool(data string) error {
-	d := strings.ToUpper(data)
-	if d != "TRUE" && d != "FALSE" {
+func IsBool(v interface{}) error {
+	if _, ok := v.(bool); !ok {
 		return IsNotBoolError
 	}
 	return nil
 }
 
-func VarcharTooLong(data string, maxLen int) error {
-	data = TrimQuotes(data)
-	d := []rune(data)
-	if len(d) > maxLen {
+func VarcharTooLong(v interface{}, maxLen int) error {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return IsNotString
+	}
+	if len([]rune(s)) > maxLen {
 		return VarCharTooLongError
 	}
 	return nil
 }
 
+// DecimalTooLong checks that v has at most precision digits when it is rounded to scale
+func DecimalTooLong(v interface{}, precision, scale int) error {
+	d, ok := decimalValue(v)
+	if !ok {
+		return IsNotDecimalError
+	}
+	if d.rescale(scale).precision() > precision {
+		return DecimalOutOfRangeError
+	}
+	return nil
+}
+
 func OptionLimit[T int | string](data T, options []T) error {
 	for _, option := range options {
 		if data == option {
@@ -94,3 +151,11 @@ func OptionLimit[T int | string](data T, options []T) error {
 	}
 	return OptionLimitError
 }
+
+// IsTime returns the constraint of a DATE, TIME or TIMESTAMP column, it accepts an ISO-8601 string too
+func IsTime(kind string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toTime(kind, v)
+		return err
+	}
+}
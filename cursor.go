@@ -0,0 +1,141 @@
+package sqlite
+
+import (
+	"math"
+)
+
+/*
+Cursor walks the leaf chain of a BPTree, forward or in reverse.
+
+The read lock is taken by every call instead of being held until Close,
+so the tree may be modified while a cursor is open, even by the goroutine
+which owns the cursor. When the tree has changed since the last call,
+the cursor seeks again from the key it is positioned at.
+
+	c := tree.NewCursor(false)
+	defer c.Close()
+	for ok := c.SeekTo(lo); ok && c.Key() < hi; ok = c.Next() {
+		fmt.Println(c.Key(), c.Value())
+	}
+*/
+type Cursor struct {
+	tree    *BPTree
+	reverse bool
+	closed  bool
+
+	node    *BPNode
+	idx     int
+	item    *BPItem // nil if the cursor is not positioned
+	version uint64
+}
+
+// NewCursor returns a cursor. A reverse cursor visits keys in descending order.
+func (t *BPTree) NewCursor(reverse bool) *Cursor {
+	return &Cursor{tree: t, reverse: reverse}
+}
+
+// First positions the cursor at the first key in its direction.
+func (c *Cursor) First() bool {
+	if c.reverse {
+		return c.SeekTo(math.MaxInt64)
+	}
+	return c.SeekTo(math.MinInt64)
+}
+
+// SeekTo positions the cursor at the first key >= key, or the last key <= key for a reverse cursor.
+func (c *Cursor) SeekTo(key int64) bool {
+	if c.closed {
+		return false
+	}
+	c.tree.mutex.RLock()
+	defer c.tree.mutex.RUnlock()
+
+	c.seek(key, c.reverse)
+	return c.item != nil
+}
+
+// Next moves the cursor to the next key in its direction.
+func (c *Cursor) Next() bool {
+	return c.move(c.reverse)
+}
+
+// Prev moves the cursor to the previous key in its direction.
+func (c *Cursor) Prev() bool {
+	return c.move(!c.reverse)
+}
+
+// Key returns the key at the cursor, the cursor must be positioned.
+func (c *Cursor) Key() int64 {
+	return c.item.Key
+}
+
+// Value returns the value at the cursor, the cursor must be positioned.
+func (c *Cursor) Value() interface{} {
+	return c.item.Val
+}
+
+func (c *Cursor) Close() {
+	c.closed = true
+	c.node, c.item = nil, nil
+}
+
+// seek must be called with the read lock held
+func (c *Cursor) seek(key int64, descending bool) {
+	if descending {
+		c.node, c.idx = c.tree.root.lastLE(key)
+	} else {
+		c.node, c.idx = c.tree.root.firstGE(key)
+	}
+	c.version = c.tree.version
+	c.item = nil
+	if c.node != nil {
+		c.item = c.node.Items[c.idx]
+	}
+}
+
+func (c *Cursor) move(descending bool) bool {
+	if c.closed || c.item == nil {
+		return false
+	}
+	c.tree.mutex.RLock()
+	defer c.tree.mutex.RUnlock()
+
+	key := c.item.Key
+	if c.version != c.tree.version {
+		// the leaves may have been split or merged, start again from the root
+		switch {
+		case descending && key == math.MinInt64, !descending && key == math.MaxInt64:
+			c.item = nil
+		case descending:
+			c.seek(key-1, true)
+		default:
+			c.seek(key+1, false)
+		}
+		return c.item != nil
+	}
+
+	if !descending {
+		c.idx++
+		for c.node != nil && c.idx >= len(c.node.Items) {
+			c.node, c.idx = c.node.Next, 0
+		}
+		c.item = nil
+		if c.node != nil {
+			c.item = c.node.Items[c.idx]
+		}
+		return c.item != nil
+	}
+
+	if c.idx > 0 {
+		c.idx--
+		c.item = c.node.Items[c.idx]
+		return true
+	}
+	// 叶子结点没有前驱指针, 从根结点查找上一个叶子结点
+	if key == math.MinInt64 {
+		c.item = nil
+		return false
+	}
+	c.seek(key-1, true)
+	return c.item != nil
+}
@@ -0,0 +1,282 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var IntervalError = fmt.Errorf("bad interval")
+
+/*
+DATE, TIME and TIMESTAMP values are time.Time in UTC. A DATE is at midnight,
+a TIME is on 0000-01-01, so that values of a column compare by their clock.
+A string is parsed as ISO-8601 when it is stored or compared with a time:
+
+	2024-01-31
+	13:45:00, 13:45
+	2024-01-31 13:45:00.123, 2024-01-31T13:45:00Z, 2024-01-31T13:45:00+08:00
+*/
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"15:04:05",
+	"15:04",
+}
+
+// parseTime parses an ISO-8601 string, the fraction of seconds is optional
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, IsNotTimeError
+}
+
+// toTime converts a time or an ISO-8601 string to a value of a DATE, TIME or TIMESTAMP column
+func toTime(kind string, v interface{}) (time.Time, error) {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v.UTC()
+	case string:
+		var err error
+		if t, err = parseTime(v); err != nil {
+			return time.Time{}, err
+		}
+	default:
+		return time.Time{}, IsNotTimeError
+	}
+	switch kind {
+	case "DATE":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case "TIME":
+		return time.Date(0, 1, 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC), nil
+	}
+	return t, nil
+}
+
+// formatTime formats a time as ISO-8601, a DATE without the clock and a TIME without the date
+func formatTime(t time.Time) string {
+	layout := "2006-01-02 15:04:05.999999999"
+	switch {
+	case t.Year() == 0 && t.YearDay() == 1:
+		layout = "15:04:05.999999999"
+	case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0:
+		layout = "2006-01-02"
+	}
+	return t.UTC().Format(layout)
+}
+
+func compareTime(x time.Time, b interface{}) (int, bool) {
+	var y time.Time
+	switch v := b.(type) {
+	case time.Time:
+		y = v
+	case string:
+		var err error
+		if y, err = parseTime(v); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	switch {
+	case x.Before(y):
+		return -1, true
+	case x.After(y):
+		return 1, true
+	}
+	return 0, true
+}
+
+// currentTime is a DEFAULT which is evaluated when a row is inserted
+type currentTime struct {
+	kind   string // CURRENT_DATE, CURRENT_TIME or CURRENT_TIMESTAMP
+	column string // the type of the column
+}
+
+func (c currentTime) now() interface{} {
+	t, _ := toTime(strings.TrimPrefix(c.kind, "CURRENT_"), time.Now())
+	t, _ = toTime(c.column, t)
+	return t
+}
+
+var currentTimes = map[string]bool{"CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true}
+
+// interval is the value of INTERVAL n unit, it is added to a time
+type interval struct {
+	months, days int
+	duration     time.Duration
+}
+
+var intervalUnits = []string{"YEAR", "MONTH", "WEEK", "DAY", "HOUR", "MINUTE", "SECOND"}
+
+func newInterval(n int, unit string) interval {
+	switch unit {
+	case "YEAR":
+		return interval{months: 12 * n}
+	case "MONTH":
+		return interval{months: n}
+	case "WEEK":
+		return interval{days: 7 * n}
+	case "DAY":
+		return interval{days: n}
+	case "HOUR":
+		return interval{duration: time.Duration(n) * time.Hour}
+	case "MINUTE":
+		return interval{duration: time.Duration(n) * time.Minute}
+	}
+	return interval{duration: time.Duration(n) * time.Second}
+}
+
+func (i interval) add(t time.Time, sign int) time.Time {
+	return t.AddDate(0, sign*i.months, sign*i.days).Add(time.Duration(sign) * i.duration)
+}
+
+func (i interval) String() string {
+	return fmt.Sprintf("%d months %d days %s", i.months, i.days, i.duration)
+}
+
+// timeArithmetic adds an interval to a time or subtracts it
+func timeArithmetic(op string, l, r interface{}) (interface{}, error) {
+	t, isTime := l.(time.Time)
+	i, isInterval := r.(interval)
+	if !isTime && op == "+" {
+		t, isTime = r.(time.Time)
+		i, isInterval = l.(interval)
+	}
+	if !isTime || !isInterval || op != "+" && op != "-" {
+		return nil, fmt.Errorf("can not %s %T and %T", op, l, r)
+	}
+	if op == "-" {
+		return i.add(t, -1), nil
+	}
+	return i.add(t, 1), nil
+}
+
+// compileInterval evaluates INTERVAL n unit, n is an integer or a string of it
+func compileInterval(e *IntervalExpr, resolve columnResolver) (evalFunc, error) {
+	amount, err := compileExpr(e.Expr, resolve)
+	if err != nil {
+		return nil, err
+	}
+	unit := e.Unit
+	return func(row []interface{}) (interface{}, error) {
+		v, err := amount(row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		switch n := v.(type) {
+		case int:
+			return newInterval(n, unit), nil
+		case string:
+			if n, err := strconv.Atoi(strings.TrimSpace(n)); err == nil {
+				return newInterval(n, unit), nil
+			}
+		}
+		return nil, fmt.Errorf("%s: %v", IntervalError, v)
+	}, nil
+}
+
+// timeArg converts an argument of a date function, 'now' is the current time
+func timeArg(name string, v interface{}) (time.Time, error) {
+	if s, ok := v.(string); ok && strings.EqualFold(s, "now") {
+		return time.Now().UTC(), nil
+	}
+	t, err := toTime("TIMESTAMP", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %v %s", name, v, err)
+	}
+	return t, nil
+}
+
+// timeFunc returns DATE(x), TIME(x) or DATETIME(x) which convert x to the kind
+func timeFunc(name, kind string) *scalarFunc {
+	return &scalarFunc{args: 1, fn: func(args []interface{}) (interface{}, error) {
+		t, err := timeArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return toTime(kind, t)
+	}}
+}
+
+func currentTimeFunc(kind string) *scalarFunc {
+	return &scalarFunc{fn: func([]interface{}) (interface{}, error) {
+		return toTime(kind, time.Now())
+	}}
+}
+
+// dateAdd returns DATE_ADD(x, INTERVAL n unit) or DATE_SUB
+func dateAdd(name, op string) *scalarFunc {
+	return &scalarFunc{args: 2, fn: func(args []interface{}) (interface{}, error) {
+		t, err := timeArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return timeArithmetic(op, t, args[1])
+	}}
+}
+
+/*
+strftime formats a time like SQLite:
+
+	%Y year  %m month  %d day  %H hour  %M minute  %S second  %f seconds with milliseconds
+	%j day of year  %w day of week, 0 is Sunday  %s unix seconds  %% a %
+*/
+func strftime(args []interface{}) (interface{}, error) {
+	format, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("STRFTIME: format %v is not a string", args[0])
+	}
+	t, err := timeArg("STRFTIME", args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'f':
+			fmt.Fprintf(&b, "%06.3f", float64(t.Second())+float64(t.Nanosecond())/1e9)
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'w':
+			b.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return nil, fmt.Errorf("STRFTIME: unknown format %%%c", format[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -2,50 +2,176 @@ package sqlite
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type DB struct {
-	Tables map[string]*Table
+	Tables map[string]*Table // keyed by the lower case name, use GetTable to look up a table
+
+	// CheckpointSize is the size of the write-ahead log which triggers a checkpoint
+	CheckpointSize int64
+
+	path       string // database file, empty for in-memory db
+	wal        *wal
+	generation uint64
+
+	txLock  sync.RWMutex // held by the running transaction, the queries outside of it share it
+	current *Tx          // the running transaction
 }
 
 func NewDB() *DB {
-	return &DB{Tables: make(map[string]*Table)}
+	return &DB{Tables: make(map[string]*Table), CheckpointSize: defaultCheckpointSize}
+}
+
+// Open loads the database file at path and replays its write-ahead log.
+// The file is created by the first checkpoint if it does not exist.
+func Open(path string) (*DB, error) {
+	db := NewDB()
+	db.path = path
+	if err := db.load(); err != nil {
+		return nil, fmt.Errorf("open %s err: %s", path, err)
+	}
+
+	records, err := readWAL(walPath(path), db.generation)
+	if err != nil {
+		return nil, fmt.Errorf("open %s err: %s", path, err)
+	}
+	if err := db.replay(records); err != nil {
+		return nil, fmt.Errorf("open %s err: %s", path, err)
+	}
+	if len(records) != 0 {
+		// 已恢复的数据先落盘,再截断日志
+		db.generation++
+		if err := db.flush(); err != nil {
+			return nil, fmt.Errorf("open %s err: %s", path, err)
+		}
+	}
+
+	if db.wal, err = createWAL(walPath(path), db.generation); err != nil {
+		return nil, fmt.Errorf("open %s err: %s", path, err)
+	}
+	return db, nil
+}
+
+// Close checkpoints the database file and removes the write-ahead log,
+// it waits for the running transaction, close the connections in BEGIN first.
+func (db *DB) Close() error {
+	if db.path == "" {
+		return nil
+	}
+
+	db.txLock.Lock()
+	defer db.txLock.Unlock()
+	if err := db.checkpoint(); err != nil {
+		return err
+	}
+	if err := db.wal.close(); err != nil {
+		return err
+	}
+	return os.Remove(walPath(db.path))
+}
+
+// Checkpoint writes all tables to the database file and truncates the write-ahead log.
+// It waits for the running transaction to finish.
+func (db *DB) Checkpoint() error {
+	db.txLock.Lock()
+	defer db.txLock.Unlock()
+	return db.checkpoint()
+}
+
+func (db *DB) checkpoint() error {
+	if db.path == "" {
+		return nil
+	}
+	db.generation++
+	if err := db.flush(); err != nil {
+		db.generation--
+		return err
+	}
+	return db.wal.reset(db.generation)
+}
+
+// journal returns where changes are recorded: the running transaction, the log or nowhere
+func (db *DB) journal() journal {
+	if db.current != nil {
+		return db.current
+	}
+	if db.wal != nil {
+		return db.wal
+	}
+	return nil
+}
+
+func (db *DB) newPlan(table *Table) *Plan {
+	plan := NewPlan(table)
+	plan.journal = db.journal()
+	return plan
+}
+
+// table names are case insensitive, as the other identifiers
+func tableKey(name string) string {
+	return strings.ToLower(name)
 }
 
 func (db *DB) AddTable(table *Table) {
-	db.Tables[table.Name] = table
+	db.Tables[tableKey(table.Name)] = table
 }
 
 func (db *DB) GetTable(tableName string) *Table {
-	return db.Tables[tableName]
+	return db.Tables[tableKey(tableName)]
 }
 
-func (db *DB) Query(sql string) ([]*BPItem, error) {
+func (db *DB) removeTable(tableName string) {
+	delete(db.Tables, tableKey(tableName))
+}
+
+// Query runs a SELECT, it waits for the running transaction, see readQuery.
+func (db *DB) Query(sql string) (*Rows, error) {
 	parser := &Parser{}
 	Type := parser.GetSQLType(sql)
 	if Type != SELECT {
 		return nil, fmt.Errorf("is not select sql")
 	}
-	return db.query(parser, sql)
+	ast, err := parser.ParseSelect(sql)
+	if err != nil {
+		return nil, err
+	}
+	return db.readQuery(ast)
 }
 
+/*
+readQuery runs a SELECT outside of a transaction. It holds txLock shared while
+it reads all the rows, so the query waits for the running transaction to finish
+and never sees its uncommitted rows. The rows are returned unlocked, a writer
+never waits for the rows which are still open.
+*/
+func (db *DB) readQuery(ast *SelectAST) (*Rows, error) {
+	db.txLock.RLock()
+	defer db.txLock.RUnlock()
+	rows, err := db.query(ast)
+	if err != nil {
+		return nil, err
+	}
+	rows.buffer()
+	return rows, nil
+}
+
+// Exec runs a statement in its own transaction, BEGIN, COMMIT and ROLLBACK run on a Conn.
 func (db *DB) Exec(sql string) error {
-	parser := &Parser{}
-	Type := parser.GetSQLType(sql)
-	switch Type {
-	case INSERT:
-		return db.Insert(parser, sql)
-	case UPDATE:
-		return db.Update(parser, sql)
-	case DELETE:
-		return db.Delete(parser, sql)
-	case CREATE:
-		return db.CreateTable(parser, sql)
-	default:
-		return fmt.Errorf("unsuported sql")
+	_, err := db.execute(sql)
+	return err
+}
+
+// execute runs a statement like Exec, it returns the number of rows changed by the statement
+func (db *DB) execute(sql string) (int64, error) {
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		return 0, err
 	}
+	return stmt.execute(nil)
 }
 
 func (db *DB) CreateTable(parser *Parser, sql string) error {
@@ -53,10 +179,103 @@ func (db *DB) CreateTable(parser *Parser, sql string) error {
 	if err != nil {
 		return err
 	}
+	return db.createTable(ast, sql)
+}
+
+// createTable creates the table of ast, sql is its statement which is logged
+func (db *DB) createTable(ast *CreateTableAST, sql string) error {
+	if db.GetTable(ast.Table) != nil {
+		if ast.IfNotExists {
+			return nil
+		}
+		return TableExistError
+	}
 	table, err := db.NewTable(ast)
 	if err != nil {
 		return fmt.Errorf("new table err: %s", err)
 	}
+	if journal := db.journal(); journal != nil {
+		if err := journal.ddl(sql, func() { db.removeTable(table.Name) }); err != nil {
+			return err
+		}
+	}
+	db.AddTable(table)
+	return nil
+}
+
+func (db *DB) CreateIndex(parser *Parser, sql string) error {
+	ast, err := parser.ParseCreateIndex(sql)
+	if err != nil {
+		return err
+	}
+	return db.createIndex(ast, sql)
+}
+
+func (db *DB) createIndex(ast *CreateIndexAST, sql string) error {
+	table := db.GetTable(ast.Table)
+	if table == nil {
+		return fmt.Errorf("has no such table: %s", ast.Table)
+	}
+	if err := table.CreateIndex(ast); err != nil {
+		return fmt.Errorf("create index %s err: %s", ast.Name, err)
+	}
+	if journal := db.journal(); journal != nil {
+		if err := journal.ddl(sql, func() { table.DropIndex(ast.Name) }); err != nil {
+			table.DropIndex(ast.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) DropTable(parser *Parser, sql string) error {
+	ast, err := parser.ParseDropTable(sql)
+	if err != nil {
+		return err
+	}
+	return db.dropTable(ast, sql)
+}
+
+func (db *DB) dropTable(ast *DropTableAST, sql string) error {
+	table := db.GetTable(ast.Table)
+	if table == nil {
+		if ast.IfExists {
+			return nil
+		}
+		return fmt.Errorf("has no such table: %s", ast.Table)
+	}
+	if journal := db.journal(); journal != nil {
+		if err := journal.ddl(sql, func() { db.AddTable(table) }); err != nil {
+			return err
+		}
+	}
+	db.removeTable(table.Name)
+	return nil
+}
+
+func (db *DB) TruncateTable(parser *Parser, sql string) error {
+	ast, err := parser.ParseTruncate(sql)
+	if err != nil {
+		return err
+	}
+	return db.truncateTable(ast, sql)
+}
+
+// truncateTable removes all rows by swapping in a copy of the table with empty trees
+func (db *DB) truncateTable(ast *TruncateAST, sql string) error {
+	old := db.GetTable(ast.Table)
+	if old == nil {
+		return fmt.Errorf("has no such table: %s", ast.Table)
+	}
+	table := old.clone()
+	for name, tree := range table.Indies {
+		table.Indies[name] = NewBPTree(tree.width, nil)
+	}
+	if journal := db.journal(); journal != nil {
+		if err := journal.ddl(sql, func() { db.AddTable(old) }); err != nil {
+			return err
+		}
+	}
 	db.AddTable(table)
 	return nil
 }
@@ -64,42 +283,57 @@ func (db *DB) CreateTable(parser *Parser, sql string) error {
 func (db *DB) NewTable(ast *CreateTableAST) (*Table, error) {
 	table := &Table{
 		Name:         ast.Table,
-		PrimaryKey:   ast.PrimaryKey,
 		Columns:      ast.Columns,
 		Indies:       map[string]*BPTree{"-": NewBPTree(17, nil)},
-		Formatter:    make(map[string]func(data string) interface{}, len(ast.Columns)),
+		Formatter:    make(map[string]func(v interface{}) interface{}, len(ast.Columns)),
 		DefaultValue: make([]interface{}, 0, len(ast.Columns)),
-		Constraint:   make(map[string]func(data string) error, len(ast.Columns)),
+		Constraint:   make(map[string]func(v interface{}) error, len(ast.Columns)),
+		Schema:       ast,
+	}
+
+	for i, col := range ast.PrimaryKey {
+		idx := table.columnIdx(col)
+		if idx == -1 {
+			return nil, fmt.Errorf("primary key %s: %s", col, HasNotColumnError)
+		}
+		ast.PrimaryKey[i] = table.Columns[idx] // spelled as the column, which keys Constraint and Formatter
+	}
+	if len(ast.PrimaryKey) == 1 {
+		table.PrimaryKey = ast.PrimaryKey[0]
 	}
 
 	for idx, col := range ast.Columns {
 		t := ast.Type[idx]
+		// a column without DEFAULT is NULL when it is not set
+		hasDefault := ast.Default[idx] != "" && !strings.EqualFold(ast.Default[idx], NULL)
 		if strings.HasPrefix(t, "INTEGER") {
 
 			table.Formatter[col] = IntegerFormatter
 
-			if ast.Default[idx] != "" {
+			if hasDefault {
 				val, err := strconv.Atoi(ast.Default[idx])
 				if err != nil {
 					return nil, err
 				}
 				table.DefaultValue = append(table.DefaultValue, val)
 			} else {
-				table.DefaultValue = append(table.DefaultValue, 0)
+				table.DefaultValue = append(table.DefaultValue, nil)
 			}
 
-			if col == ast.PrimaryKey {
+			if col == table.PrimaryKey {
 				table.Constraint[col] = Compose(IsInteger, NotEmpty)
 			} else {
-				table.Constraint[col] = IsInteger
+				table.Constraint[col] = Nullable(IsInteger)
 			}
 
 		} else if strings.HasPrefix(t, "VARCHAR") {
 			table.Formatter[col] = StringFormatter
 
-			_default := ast.Default[idx]
-			_default = TrimQuotes(_default)
-			table.DefaultValue = append(table.DefaultValue, _default)
+			if hasDefault {
+				table.DefaultValue = append(table.DefaultValue, unquote(ast.Default[idx]))
+			} else {
+				table.DefaultValue = append(table.DefaultValue, nil)
+			}
 
 			_type := t
 			_type = strings.TrimLeft(_type, "VARCHAR")
@@ -109,100 +343,211 @@ func (db *DB) NewTable(ast *CreateTableAST) (*Table, error) {
 			if err != nil {
 				return nil, err
 			}
-			table.Constraint[col] = func(data string) error { return VarcharTooLong(data, length) }
+			table.Constraint[col] = Nullable(func(v interface{}) error { return VarcharTooLong(v, length) })
+
+		} else if t == "REAL" || t == "DOUBLE" {
+			table.Formatter[col] = RealFormatter
+
+			var val interface{}
+			if hasDefault {
+				f, err := strconv.ParseFloat(ast.Default[idx], 64)
+				if err != nil {
+					return nil, err
+				}
+				val = f
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(IsReal)
+
+		} else if t == "BOOLEAN" {
+			table.Formatter[col] = BoolFormatter
+
+			var val interface{}
+			if hasDefault {
+				b, err := strconv.ParseBool(ast.Default[idx])
+				if err != nil {
+					return nil, err
+				}
+				val = b
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(IsBool)
+
+		} else if t == "TEXT" {
+			table.Formatter[col] = StringFormatter
+
+			var val interface{}
+			if hasDefault {
+				val = unquote(ast.Default[idx])
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(IsString)
+
+		} else if t == "BLOB" {
+			table.Formatter[col] = BlobFormatter
+
+			var val interface{}
+			if hasDefault {
+				val = []byte(unquote(ast.Default[idx]))
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(IsBlob)
+
+		} else if t == "DATE" || t == "TIME" || t == "TIMESTAMP" {
+			table.Formatter[col] = TimeFormatter(t)
+
+			var val interface{}
+			if def := strings.ToUpper(ast.Default[idx]); currentTimes[def] {
+				val = currentTime{kind: def, column: t}
+			} else if hasDefault {
+				tm, err := toTime(t, unquote(ast.Default[idx]))
+				if err != nil {
+					return nil, err
+				}
+				val = tm
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(IsTime(t))
+
+		} else if strings.HasPrefix(t, "DECIMAL") {
+			var precision, scale int
+			if _, err := fmt.Sscanf(t, "DECIMAL(%d,%d)", &precision, &scale); err != nil {
+				return nil, err
+			}
+			table.Formatter[col] = DecimalFormatter(scale)
+
+			var val interface{}
+			if hasDefault {
+				d, err := ParseDecimal(ast.Default[idx])
+				if err != nil {
+					return nil, err
+				}
+				val = d.rescale(scale)
+			}
+			table.DefaultValue = append(table.DefaultValue, val)
+			table.Constraint[col] = Nullable(func(v interface{}) error { return DecimalTooLong(v, precision, scale) })
 		}
 
+		// the columns of the primary key are NOT NULL too
+		if (ast.NotNull[idx] || containsColumn(ast.PrimaryKey, col)) && table.Constraint[col] != nil {
+			table.Constraint[col] = Compose(NotNull, table.Constraint[col])
+		}
 	}
 
+	if err := table.buildPrimaryIndex(); err != nil {
+		return nil, err
+	}
 	return table, nil
 }
 
-func (db *DB) Delete(parser *Parser, sql string) error {
+func (db *DB) Delete(parser *Parser, sql string) (int64, error) {
 	ast, err := parser.ParseDelete(sql)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return db.delete(ast)
+}
+
+func (db *DB) delete(ast *DeleteAST) (int64, error) {
 	table := db.GetTable(ast.Table)
 	if table == nil {
-		return fmt.Errorf("has no such table: %s", ast.Table)
+		return 0, fmt.Errorf("has no such table: %s", ast.Table)
+	}
+	resolved, err := resolveQuoted(ast, table)
+	if err != nil {
+		return 0, err
 	}
+	ast = resolved.(*DeleteAST)
 
 	constraintErr := table.CheckDeleteConstraint(ast)
 	if constraintErr != nil {
-		return fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
+		return 0, fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
 	}
 
-	if err := NewPlan(table).Delete(ast); err != nil {
-		return err
-	}
-	return nil
+	return db.newPlan(table).Delete(ast)
 }
 
-func (db *DB) Insert(parser *Parser, sql string) error {
+func (db *DB) Insert(parser *Parser, sql string) (int64, error) {
 	ast, err := parser.ParseInsert(sql)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return db.insert(ast)
+}
 
+func (db *DB) insert(ast *InsertAST) (int64, error) {
 	table := db.GetTable(ast.Table)
 	if table == nil {
-		return fmt.Errorf("has no such table: %s", ast.Table)
+		return 0, fmt.Errorf("has no such table: %s", ast.Table)
+	}
+	resolved, err := resolveQuoted(ast) // VALUES has no columns
+	if err != nil {
+		return 0, err
 	}
+	ast = resolved.(*InsertAST)
 
 	constraintErr := table.CheckInsertConstraint(ast)
 	if constraintErr != nil {
-		return fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
+		return 0, fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
 	}
 
-	dataset := table.Format(ast)
-
-	if err := NewPlan(table).Insert(dataset); err != nil {
-		return err
+	dataset, err := table.Format(ast)
+	if err != nil {
+		return 0, err
 	}
-
-	return nil
+	return db.newPlan(table).Insert(dataset)
 }
 
-func (db *DB) Update(parser *Parser, sql string) error {
+func (db *DB) Update(parser *Parser, sql string) (int64, error) {
 	ast, err := parser.ParseUpdate(sql)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return db.update(ast)
+}
+
+func (db *DB) update(ast *UpdateAST) (int64, error) {
 	table := db.GetTable(ast.Table)
 	if table == nil {
-		return fmt.Errorf("has no such table: %s", ast.Table)
+		return 0, fmt.Errorf("has no such table: %s", ast.Table)
 	}
+	resolved, err := resolveQuoted(ast, table)
+	if err != nil {
+		return 0, err
+	}
+	ast = resolved.(*UpdateAST)
 	constraintErr := table.CheckUpdateConstraint(ast)
 	if constraintErr != nil {
-		return fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
+		return 0, fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
 	}
 
-	if err := NewPlan(table).Update(ast); err != nil {
-		return err
-	}
-	return nil
+	return db.newPlan(table).Update(ast)
 }
 
-func (db *DB) query(parser *Parser, sql string) ([]*BPItem, error) {
-	ast, err := parser.ParseSelect(sql)
-	if err != nil {
-		return nil, err
-	}
-
+func (db *DB) query(ast *SelectAST) (*Rows, error) {
 	table := db.GetTable(ast.Table)
 	if table == nil {
 		return nil, fmt.Errorf("has no such table: %s", ast.Table)
 	}
 
+	var joined []*Table
+	for _, join := range ast.Joins {
+		joinedTable := db.GetTable(join.Table)
+		if joinedTable == nil {
+			return nil, fmt.Errorf("has no such table: %s", join.Table)
+		}
+		joined = append(joined, joinedTable)
+	}
+	resolved, err := resolveQuoted(ast, append([]*Table{table}, joined...)...)
+	if err != nil {
+		return nil, err
+	}
+	ast = resolved.(*SelectAST)
+
 	constraintErr := table.CheckSelectConstraint(ast)
 	if constraintErr != nil {
 		return nil, fmt.Errorf("column %s. err: %s", constraintErr.Column, constraintErr.Err)
 	}
 
-	plan := NewPlan(table)
-	result, err := plan.Select(ast)
-	if err != nil {
-		return nil, err
-	}
-	return result, nil
+	return NewPlan(table, joined...).Query(ast)
 }
@@ -0,0 +1,1339 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const createUserSQL = `
+	CREATE TABLE user (
+		email      VARCHAR(255)   NOT NULL  DEFAULT "default@gmail.com",
+		username   VARCHAR(16)    NOT NULL,
+		id         INTEGER        NOT NULL,
+		PRIMARY KEY (id)
+	);`
+
+func newUserDB(t testing.TB, db *DB, rows int) {
+	if err := db.Exec(createUserSQL); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= rows; i++ {
+		sql := fmt.Sprintf(`INSERT INTO user (id, username, email) VALUES (%d, "userName-%d", "User-%d@gmail.com")`, i, i, i)
+		if err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUserDB(t, db, 500)
+	if err := db.Exec(`DELETE FROM user WHERE id < 100`); err != nil {
+		t.Fatal(err)
+	}
+	want := db.GetTable("user").GetClusterIndex().GetData()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := db.GetTable("user")
+	if table == nil {
+		t.Fatal("table user is not reloaded")
+	}
+	if got := table.GetClusterIndex().GetData(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded tree differs")
+	}
+
+	// the reloaded tree must still accept writes
+	if err := db.Exec(`INSERT INTO user (id, username) VALUES (1000, "new")`); err != nil {
+		t.Fatal(err)
+	}
+	result := queryValues(t, db, `SELECT * FROM user WHERE id = 1000`)
+	if !reflect.DeepEqual(result, [][]interface{}{{"default@gmail.com", "new", 1000}}) {
+		t.Errorf("unexpected result %v", result)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeCorruptCount(t *testing.T) {
+	huge := uint64(1) << 60
+
+	// node 1 with max key 0 is a leaf claiming huge items
+	leaf := binary.AppendVarint(binary.AppendUvarint(nil, 1), 0)
+	leaf = binary.AppendUvarint(append(leaf, 1), huge)
+	if _, _, err := decodeNode(leaf); err == nil {
+		t.Errorf("expect error for a leaf of %d items", huge)
+	}
+
+	d := &decoder{buf: binary.AppendUvarint([]byte{tagList}, huge)}
+	if d.value(); d.err == nil {
+		t.Errorf("expect error for a list of %d values", huge)
+	}
+}
+
+func countRows(t *testing.T, db *DB, where string) int {
+	return len(queryValues(t, db, `SELECT * FROM user`+where))
+}
+
+func TestTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUserDB(t, db, 10)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Exec(`DELETE FROM user WHERE id > 5`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Exec(`UPDATE user SET username = "changed" WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	// the transaction sees its own changes, DB.Query would wait for it
+	rows, err := tx.Query(`SELECT id FROM user`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("expect 5 rows in transaction, got %d", n)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 10 {
+		t.Errorf("expect 10 rows after rollback, got %d", n)
+	}
+	if n := countRows(t, db, ` WHERE username = "changed"`); n != 0 {
+		t.Errorf("update is not rolled back")
+	}
+	if err := tx.Exec(`DELETE FROM user WHERE id > 5`); err != TxDoneError {
+		t.Errorf("expect TxDoneError, got %v", err)
+	}
+
+	// a failed statement is undone, the rest of the transaction is kept
+	if err := db.Exec(`BEGIN`); err != TxStatementError {
+		t.Errorf("expect TxStatementError, got %v", err)
+	}
+	conn := db.Conn()
+	if err := conn.Exec(`BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Exec(`INSERT INTO user (id, username) VALUES (11, "u11")`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Exec(`UPDATE user SET id = 2 WHERE id = 1`); err != DuplicateKeyError {
+		t.Errorf("expect DuplicateKeyError, got %v", err)
+	}
+	if err := conn.Exec(`COMMIT`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Exec(`COMMIT`); err != NoTxError {
+		t.Errorf("expect NoTxError, got %v", err)
+	}
+
+	// the log only holds the committed transaction
+	if err := db.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 11 {
+		t.Errorf("expect 11 rows after reopen, got %d", n)
+	}
+	if n := countRows(t, db, ` WHERE id = 1`); n != 1 {
+		t.Errorf("row 1 is lost")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentBegin is meant to be run with -race too
+func TestConcurrentBegin(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 0)
+
+	const workers, txs = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	wg.Add(workers + 1)
+	go func() {
+		// BEGIN on a connection, the Begin of the others waits for its COMMIT
+		defer wg.Done()
+		conn := db.Conn()
+		defer conn.Close()
+		for i := 0; i < txs; i++ {
+			for _, sql := range []string{`BEGIN`, fmt.Sprintf(`INSERT INTO user (id, username) VALUES (%d, "s")`, i+1), `COMMIT`} {
+				if err := conn.Exec(sql); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}
+	}()
+	for w := 1; w <= workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < txs; i++ {
+				tx, err := db.Begin()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := tx.Exec(fmt.Sprintf(`INSERT INTO user (id, username) VALUES (%d, "w")`, w*txs+i+1)); err != nil {
+					tx.Rollback()
+					errs <- err
+					return
+				}
+				if err := tx.Commit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != (workers+1)*txs {
+		t.Errorf("expect %d rows, got %d", (workers+1)*txs, n)
+	}
+}
+
+// TestConnIsolation is meant to be run with -race too
+func TestConnIsolation(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 0)
+	conn := db.Conn()
+	if err := conn.Exec(`BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the statements of the connection run one at a time in its transaction
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := conn.Exec(fmt.Sprintf(`INSERT INTO user (id, username) VALUES (%d, "conn")`, i*100+j)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	rows, err := conn.Query(`SELECT COUNT(*) FROM user WHERE username = "conn"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() || rows.Values()[0] != 100 {
+		t.Errorf("expect the connection sees its 100 rows, got %v %v", rows.Values(), rows.Err())
+	}
+	rows.Close()
+
+	// the others wait for ROLLBACK and never see the rows of the connection
+	done := make(chan int, 2)
+	go func() {
+		if err := db.Exec(`INSERT INTO user (id, username) VALUES (1, "other")`); err != nil {
+			t.Error(err)
+		}
+		done <- -1
+	}()
+	go func() {
+		done <- countRows(t, db, ` WHERE username = "conn"`)
+	}()
+	select {
+	case <-done:
+		t.Fatal("a statement outside of the connection does not wait for its transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := conn.Exec(`ROLLBACK`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if n := <-done; n > 0 {
+			t.Errorf("expect the rolled back rows are not seen, got %d", n)
+		}
+	}
+	if n := countRows(t, db, ""); n != 1 {
+		t.Errorf("expect only the row of the other statement, got %d", n)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenRowsDoNotBlockWriters(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 3)
+
+	done := make(chan error, 1)
+	go func() {
+		// a write inside the loop over the rows, and rows which are never closed
+		rows, err := db.Query(`SELECT id FROM user`)
+		if err != nil {
+			done <- err
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				done <- err
+				return
+			}
+			if err := db.Exec(fmt.Sprintf(`UPDATE user SET username = "seen" WHERE id = %d`, id)); err != nil {
+				done <- err
+				return
+			}
+		}
+		if _, err := db.Query(`SELECT * FROM user`); err != nil {
+			done <- err
+			return
+		}
+		done <- db.Exec(`DELETE FROM user WHERE id = 1`)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a writer waits for the open rows")
+	}
+	if n := countRows(t, db, ` WHERE username = "seen"`); n != 2 {
+		t.Errorf("expect 2 rows updated in the loop, got %d", n)
+	}
+}
+
+func TestMultiRowInsert(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 3)
+
+	err := db.Exec(`INSERT INTO user (id, username) VALUES (4, "u4"), (5, "u5"), (3, "u3")`)
+	if err != DuplicateKeyError {
+		t.Errorf("expect DuplicateKeyError, got %v", err)
+	}
+	err = db.Exec(`INSERT INTO user (id, username) VALUES (6, "u6"), (7, "u7"), (6, "again")`)
+	if err != DuplicateKeyError {
+		t.Errorf("expect DuplicateKeyError, got %v", err)
+	}
+	if n := countRows(t, db, ""); n != 3 {
+		t.Errorf("expect no row of a failed INSERT, got %d rows", n)
+	}
+
+	if err := db.Exec(`INSERT INTO user (id, username) VALUES (9, "u9"), (8, "u8")`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 5 {
+		t.Errorf("expect 5 rows, got %d", n)
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUserDB(t, db, 100)
+	if err := db.Exec(`CREATE INDEX idx_username ON user (username)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE UNIQUE INDEX idx_email ON user (email)`); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := NewPlan(db.GetTable("user"))
+	if n := countCandidates(plan, `username = "userName-42"`); n != 1 {
+		t.Errorf("expect index lookup of 1 row, got %d", n)
+	}
+	// User-9@gmail.com, User-90@gmail.com ... User-99@gmail.com
+	if n := countCandidates(plan, `email > "User-9" and username < "z"`); n != 11 {
+		t.Errorf("expect index range of 11 rows, got %d", n)
+	}
+	if n := countRows(t, db, ` WHERE email > "User-9" AND id < 50`); n != 1 {
+		t.Errorf("expect 1 row, got %d", n)
+	}
+
+	err = db.Exec(`INSERT INTO user (id, username, email) VALUES (101, "u101", "User-1@gmail.com")`)
+	if err != DuplicateKeyError {
+		t.Errorf("expect DuplicateKeyError, got %v", err)
+	}
+	if err := db.Exec(`UPDATE user SET username = "same" WHERE id < 11`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`DELETE FROM user WHERE username = "same" AND id > 5`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ` WHERE username = "same"`); n != 5 {
+		t.Errorf("expect 5 rows, got %d", n)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.GetTable("user").Indies["idx_email"]; !ok {
+		t.Fatal("index is not reloaded")
+	}
+	if n := countRows(t, db, ` WHERE username = "same"`); n != 5 {
+		t.Errorf("expect 5 rows after reopen, got %d", n)
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUserDB(t, db, 3)
+	if err := db.Exec(`CREATE INDEX idx_username ON user (username)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`ALTER TABLE user ADD COLUMN age INTEGER NOT NULL DEFAULT 18`,
+		`ALTER TABLE user ADD nickname VARCHAR(8) DEFAULT 'none'`,
+		`ALTER TABLE user DROP COLUMN email`,
+		`ALTER TABLE user RENAME COLUMN username TO name`,
+		`ALTER TABLE user RENAME TO member`,
+		`INSERT INTO member (id, name, age) VALUES (4, "u4", 30)`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	want := [][]interface{}{{"userName-2", 2, 18, "none"}, {"u4", 4, 30, "none"}}
+	check := func(when string) {
+		if db.GetTable("user") != nil {
+			t.Errorf("%s: table user is not renamed", when)
+		}
+		result := queryValues(t, db, `SELECT * FROM member WHERE name = "userName-2" OR age > 18`)
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("%s: got %v, want %v", when, result, want)
+		}
+	}
+	check("after alter")
+
+	for _, sql := range []string{
+		`ALTER TABLE member ADD age INTEGER`,
+		`ALTER TABLE member DROP COLUMN id`,
+		`ALTER TABLE member DROP COLUMN name`,
+		`ALTER TABLE member DROP COLUMN email`,
+		`ALTER TABLE member RENAME COLUMN age TO name`,
+		`ALTER TABLE user ADD x INTEGER`,
+	} {
+		if err := db.Exec(sql); err == nil {
+			t.Errorf("%s: expect error", sql)
+		}
+	}
+
+	// the log replays the statements
+	if err := db.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after replay")
+
+	// the file holds the new schema
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableName(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 3)
+
+	if err := db.Exec(createUserSQL); err != TableExistError {
+		t.Errorf("expect TableExistError, got %v", err)
+	}
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS USER (id INTEGER, PRIMARY KEY (id))`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 3 {
+		t.Errorf("expect the table is kept, got %d rows", n)
+	}
+
+	// names are case insensitive in every statement
+	for _, sql := range []string{
+		`INSERT INTO User (id, username) VALUES (4, "u4")`,
+		`UPDATE USER SET username = "changed" WHERE id = 4`,
+		`DELETE FROM uSeR WHERE id = 1`,
+		`CREATE INDEX idx_username ON User (username)`,
+		`CREATE TABLE "Order" (id INTEGER, uid INTEGER, PRIMARY KEY (id))`,
+		`INSERT INTO "ORDER" (id, uid) VALUES (1, 4)`,
+		`ALTER TABLE USER RENAME TO Member`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	result := queryValues(t, db, `SELECT MEMBER.username FROM Member JOIN "order" o ON o.uid = member.id`)
+	if !reflect.DeepEqual(result, [][]interface{}{{"changed"}}) {
+		t.Errorf("unexpected result %v", result)
+	}
+	if name := db.GetTable("member").Name; name != "Member" {
+		t.Errorf("expect the name as written, got %s", name)
+	}
+
+	// column names are case insensitive too, the table keeps them as written
+	for _, sql := range []string{
+		`CREATE TABLE account (Id INTEGER, userName VARCHAR(8), PRIMARY KEY (ID))`,
+		`INSERT INTO account (ID, USERNAME) VALUES (1, 'abc')`,
+		`CREATE INDEX idx_name ON account (UserName)`,
+		`ALTER TABLE account RENAME COLUMN USERNAME TO Nick`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	result = queryValues(t, db, `SELECT id, nick FROM account WHERE NICK = 'abc'`)
+	if !reflect.DeepEqual(result, [][]interface{}{{1, "abc"}}) {
+		t.Errorf("unexpected result %v", result)
+	}
+	if err := db.Exec(`ALTER TABLE account DROP COLUMN NICK`); err == nil {
+		t.Errorf("expect error for an indexed column")
+	}
+
+	if err := db.Exec(`ALTER TABLE member RENAME TO ORDER`); err == nil {
+		t.Errorf("expect error for existing table")
+	}
+	if err := db.Exec(`CREATE TABLE IF user (id INTEGER, PRIMARY KEY (id))`); err == nil {
+		t.Errorf("expect syntax error")
+	}
+}
+
+func TestQuotedName(t *testing.T) {
+	db := NewDB()
+	for _, sql := range []string{
+		`CREATE TABLE t (col INTEGER, name VARCHAR(16), PRIMARY KEY (col))`,
+		`INSERT INTO t (col, name) VALUES (1, "col"), (2, "x")`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+
+	// "name" is the column if the table has it, a string otherwise
+	for sql, want := range map[string][][]interface{}{
+		`SELECT "col" FROM t WHERE "col" = 1`:                  {{1}},
+		`SELECT "col", "nosuch" FROM t WHERE name = "x"`:       {{2, "nosuch"}},
+		`SELECT col FROM t WHERE "name" = 'col'`:               {{1}},
+		`SELECT "COL" FROM t GROUP BY col ORDER BY "col" DESC`: {{2}, {1}},
+	} {
+		if result := queryValues(t, db, sql); !reflect.DeepEqual(result, want) {
+			t.Errorf("%s: expect %v, got %v", sql, want, result)
+		}
+	}
+	if _, err := db.Query(`SELECT t.col FROM t JOIN t u ON "col" = u.col`); err == nil {
+		t.Errorf("expect error for ambiguous column")
+	}
+
+	if err := db.Exec(`UPDATE t SET "col" = "col" + 10 WHERE "col" = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if result := queryValues(t, db, `SELECT col FROM t WHERE name = "x"`); !reflect.DeepEqual(result, [][]interface{}{{12}}) {
+		t.Errorf("expect the value of col, got %v", result)
+	}
+}
+
+func TestNull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`CREATE TABLE person (
+			id    INTEGER     NOT NULL,
+			name  VARCHAR(16) NOT NULL,
+			email VARCHAR(32) DEFAULT NULL,
+			age   INTEGER,
+			PRIMARY KEY (id)
+		)`,
+		`CREATE UNIQUE INDEX idx_email ON person (email)`,
+		`INSERT INTO person (id, name, age) VALUES (1, "a", 20), (2, "b", NULL), (3, "", 30)`,
+		`INSERT INTO person (id, name, email) VALUES (4, "d", "d@x.com")`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	for _, sql := range []string{
+		`INSERT INTO person (id, name) VALUES (5, NULL)`,
+		`INSERT INTO person (id, age) VALUES (5, 1)`,
+		`UPDATE person SET name = NULL WHERE id = 1`,
+		`ALTER TABLE person ADD COLUMN score INTEGER NOT NULL`,
+	} {
+		if err := db.Exec(sql); err == nil {
+			t.Errorf("%s: expect error", sql)
+		}
+	}
+
+	// the constraints of a mixed case column hold whatever case INSERT uses
+	if err := db.Exec(`CREATE TABLE member (id INTEGER, userName VARCHAR(3) NOT NULL, PRIMARY KEY (id))`); err != nil {
+		t.Fatal(err)
+	}
+	for sql, want := range map[string]error{
+		`INSERT INTO member (id, userName) VALUES (1, 'abcdef')`: VarCharTooLongError,
+		`INSERT INTO member (id, username) VALUES (1, NULL)`:     NotNullError,
+		`INSERT INTO member VALUES (1, NULL)`:                    NotNullError,
+		`INSERT INTO member (id) VALUES (1)`:                     NotNullError,
+	} {
+		if err := db.Exec(sql); err == nil || !strings.Contains(err.Error(), want.Error()) {
+			t.Errorf("%s: expect %v, got %v", sql, want, err)
+		}
+	}
+	if n := len(queryValues(t, db, `SELECT * FROM member`)); n != 0 {
+		t.Errorf("expect no row is inserted, got %d", n)
+	}
+
+	check := func(when string) {
+		for sql, want := range map[string][][]interface{}{
+			`SELECT id FROM person WHERE email IS NULL`:                   {{1}, {2}, {3}},
+			`SELECT id FROM person WHERE age IS NOT NULL AND name = ""`:   {{3}},
+			`SELECT id FROM person WHERE age = NULL OR age != 20`:         {{3}},
+			`SELECT id FROM person WHERE NOT (age = 20)`:                  {{3}},
+			`SELECT id FROM person WHERE email > "a"`:                     {{4}},
+			`SELECT COUNT(*), COUNT(age), SUM(age), AVG(age) FROM person`: {{4, 2, 50, 25.0}},
+			`SELECT id, age FROM person ORDER BY age DESC, id`:            {{3, 30}, {1, 20}, {2, nil}, {4, nil}},
+			`SELECT age, COUNT(*) FROM person GROUP BY age ORDER BY age`:  {{nil, 2}, {20, 1}, {30, 1}},
+			`SELECT age + 1 FROM person WHERE id = 2`:                     {{nil}},
+		} {
+			if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: got %v, want %v", when, sql, got, want)
+			}
+		}
+	}
+	check("before reopen")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	rows, err := db.Query(`SELECT email FROM person WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var email *string
+	if !rows.Next() || rows.Scan(&email) != nil || email != nil {
+		t.Errorf("expect NULL, got %v", email)
+	}
+	rows.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`CREATE TABLE sensor (
+			id     INTEGER NOT NULL,
+			value  REAL    DEFAULT -1.5,
+			ratio  DOUBLE,
+			active BOOLEAN DEFAULT TRUE,
+			note   TEXT    DEFAULT 'none',
+			data   BLOB    DEFAULT X'00ff',
+			PRIMARY KEY (id)
+		)`,
+		`CREATE INDEX idx_value ON sensor (value)`,
+		`INSERT INTO sensor (id, value, ratio, active, note, data) VALUES (1, 2, 0.5, FALSE, "a long text", X'0A0B')`,
+		`INSERT INTO sensor (id, value) VALUES (2, 3.25), (3, -10)`,
+		`INSERT INTO sensor (id) VALUES (4)`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	insert, err := db.Prepare(`INSERT INTO sensor (id, ratio, active, data) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := insert.Exec(5, float32(0.25), true, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{
+		{6, "0.5", true, nil}, // string into REAL
+		{6, 0.5, 1, nil},      // integer into BOOLEAN
+		{6, 0.5, true, 1},     // integer into BLOB
+	} {
+		if err := insert.Exec(args...); err == nil {
+			t.Errorf("%v: expect error", args)
+		}
+	}
+
+	check := func(when string) {
+		for sql, want := range map[string][][]interface{}{
+			`SELECT value, active, note, data FROM sensor WHERE id = 4`: {{-1.5, true, "none", []byte{0, 0xff}}},
+			`SELECT id FROM sensor WHERE value > 0 ORDER BY value DESC`: {{2}, {1}},
+			`SELECT id FROM sensor WHERE value >= -1.5 AND value < 2`:   {{4}, {5}},
+			`SELECT id FROM sensor WHERE NOT active`:                    {{1}},
+			`SELECT id FROM sensor WHERE data = X'0102'`:                {{5}},
+			`SELECT id, ratio * 2 FROM sensor WHERE ratio IS NOT NULL`:  {{1, 1.0}, {5, 0.5}},
+			`SELECT SUM(value), MAX(note) FROM sensor`:                  {{-7.75, "none"}},
+		} {
+			if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: got %v, want %v", when, sql, got, want)
+			}
+		}
+	}
+	check("before reopen")
+	if n := countCandidates(NewPlan(db.GetTable("sensor")), `value > 0`); n != 2 {
+		t.Errorf("expect index range of 2 rows, got %d", n)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	rows, err := db.Query(`SELECT value, active, note, data FROM sensor WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanTypes []reflect.Type
+	for _, col := range rows.ColumnTypes() {
+		scanTypes = append(scanTypes, col.ScanType)
+	}
+	if want := []reflect.Type{reflect.TypeOf(0.0), reflect.TypeOf(false), reflect.TypeOf(""), reflect.TypeOf([]byte{})}; !reflect.DeepEqual(scanTypes, want) {
+		t.Errorf("got scan types %v, want %v", scanTypes, want)
+	}
+	var value float64
+	var active bool
+	var note, data string
+	if !rows.Next() || rows.Scan(&value, &active, &note, &data) != nil {
+		t.Fatal(rows.Err())
+	}
+	if value != 2 || active || note != "a long text" || data != "\n\v" {
+		t.Errorf("unexpected row %v %v %q %q", value, active, note, data)
+	}
+	rows.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UTC().Add(-time.Second)
+	for _, sql := range []string{
+		`CREATE TABLE event (
+			id      INTEGER   NOT NULL,
+			day     DATE      DEFAULT '2024-01-01',
+			at      TIME,
+			created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id)
+		)`,
+		`CREATE INDEX idx_day ON event (day)`,
+		`INSERT INTO event (id, day, at, created) VALUES (1, '2024-02-28', '09:30', '2024-02-28T23:59:59.5+08:00')`,
+		`INSERT INTO event (id, day, at) VALUES (2, '2024-03-01 12:00:00', '18:00:05'), (3, '2023-12-31', NULL)`,
+		`INSERT INTO event (id) VALUES (4)`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	insert, err := db.Prepare(`INSERT INTO event (id, day) VALUES (?, ?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := insert.Exec(5, time.Date(2024, 3, 1, 22, 0, 0, 0, time.FixedZone("", 2*3600))); err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []interface{}{"2024-13-01", "yesterday", 20240101} {
+		if err := insert.Exec(6, day); err == nil {
+			t.Errorf("%v: expect error", day)
+		}
+	}
+
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	check := func(when string) {
+		for sql, want := range map[string][][]interface{}{
+			`SELECT day, at FROM event WHERE id = 1`:                                                  {{date(2024, 2, 28), time.Date(0, 1, 1, 9, 30, 0, 0, time.UTC)}},
+			`SELECT created FROM event WHERE id = 1`:                                                  {{time.Date(2024, 2, 28, 15, 59, 59, 5e8, time.UTC)}},
+			`SELECT id FROM event WHERE day = '2024-03-01' ORDER BY id`:                               {{2}, {5}},
+			`SELECT id FROM event WHERE day < '2024-01-01'`:                                           {{3}},
+			`SELECT id FROM event WHERE at > '12:00' `:                                                {{2}},
+			`SELECT id, day FROM event WHERE day >= DATE('2024-02-29 10:00')`:                         {{2, date(2024, 3, 1)}, {5, date(2024, 3, 1)}},
+			`SELECT STRFTIME('%Y/%m/%d %H:%M:%f %j %w %%', created) FROM event WHERE id = 1`:          {{"2024/02/28 15:59:59.500 059 3 %"}},
+			`SELECT day + INTERVAL 1 DAY, DATE_SUB(day, INTERVAL 1 MONTH) FROM event WHERE id = 1`:    {{date(2024, 2, 29), date(2024, 1, 28)}},
+			`SELECT DATE_ADD(day, INTERVAL '2' YEARS), day - INTERVAL 2 WEEK FROM event WHERE id = 3`: {{date(2025, 12, 31), date(2023, 12, 17)}},
+			`SELECT TIME(at + INTERVAL -30 MINUTE) FROM event WHERE id = 2`:                           {{time.Date(0, 1, 1, 17, 30, 5, 0, time.UTC)}},
+			`SELECT DATETIME('2024-01-31T08:00:00Z') + INTERVAL 1 MONTH FROM event WHERE id = 4`:      {{time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)}},
+			`SELECT MIN(day), MAX(day) FROM event`:                                                    {{date(2023, 12, 31), date(2024, 3, 1)}},
+		} {
+			if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: got %v, want %v", when, sql, got, want)
+			}
+		}
+		created := queryValues(t, db, `SELECT created FROM event WHERE id = 4`)[0][0].(time.Time)
+		if created.Before(before) || created.After(time.Now()) {
+			t.Errorf("%s: CURRENT_TIMESTAMP default is %s", when, created)
+		}
+	}
+	check("before reopen")
+	if n := countCandidates(NewPlan(db.GetTable("event")), `day >= '2024-03-01'`); n != 2 {
+		t.Errorf("expect index range of 2 rows, got %d", n)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	for _, sql := range []string{
+		`SELECT DATE('tomorrow') FROM event`,
+		`SELECT STRFTIME('%Q', day) FROM event`,
+		`SELECT DATE_ADD(day, 1) FROM event`,
+		`SELECT day * 2 FROM event`,
+	} {
+		if err := queryError(db, sql); err == nil {
+			t.Errorf("%s: expect error", sql)
+		}
+	}
+
+	rows, err := db.Query(`SELECT day, at, CURRENT_DATE FROM event WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanTypes []reflect.Type
+	for _, col := range rows.ColumnTypes() {
+		scanTypes = append(scanTypes, col.ScanType)
+	}
+	if want := []reflect.Type{reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{})}; !reflect.DeepEqual(scanTypes, want) {
+		t.Errorf("got scan types %v, want %v", scanTypes, want)
+	}
+	var day, at string
+	var today time.Time
+	if !rows.Next() || rows.Scan(&day, &at, &today) != nil {
+		t.Fatal(rows.Err())
+	}
+	if day != "2024-02-28" || at != "09:30:00" || today.Year() < 2024 {
+		t.Errorf("unexpected row %q %q %s", day, at, today)
+	}
+	rows.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`CREATE TABLE invoice (
+			id     INTEGER       NOT NULL,
+			amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+			rate   NUMERIC(5, 4) DEFAULT 0.0825,
+			qty    NUMERIC,
+			PRIMARY KEY (id)
+		)`,
+		`CREATE INDEX idx_amount ON invoice (amount)`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	insert, err := db.Prepare(`INSERT INTO invoice (id, amount, qty) VALUES (?, ?, ?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ten cents ten times, a float64 sum is 0.9999999999999999
+	for id := 1; id <= 10; id++ {
+		if err := insert.Exec(id, 0.1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]interface{}{
+		{11, "19.999", 2.5},        // rounded to the scale
+		{12, "-12345678.904", nil}, // 10 digits
+	} {
+		if err := insert.Exec(args...); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	for _, args := range [][]interface{}{
+		{14, "123456789.1", nil}, // 11 digits
+		{14, "99999999.995", nil},
+		{14, "1e3", nil},
+		{14, true, nil},
+	} {
+		if err := insert.Exec(args...); err == nil {
+			t.Errorf("%v: expect error", args)
+		}
+	}
+	for _, sql := range []string{
+		`INSERT INTO invoice (id, qty) VALUES (13, -2.5)`,
+		`UPDATE invoice SET amount = amount * 1.5 WHERE id = 13`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+
+	check := func(when string) {
+		for sql, want := range map[string]string{
+			`SELECT SUM(amount) FROM invoice WHERE id <= 10`:                                   `[[1.00]]`,
+			`SELECT amount, rate, qty FROM invoice WHERE id = 11`:                              `[[20.00 0.0825 3]]`,
+			`SELECT amount, qty FROM invoice WHERE id = 13`:                                    `[[0.00 -3]]`,
+			`SELECT id FROM invoice WHERE amount > 0.1 OR amount < -1`:                         `[[11] [12]]`,
+			`SELECT COUNT(*) FROM invoice WHERE amount = 0.10`:                                 `[[10]]`,
+			`SELECT id FROM invoice WHERE qty >= 1 AND id > 5 ORDER BY qty DESC, id LIMIT 2`:   `[[11] [6]]`,
+			`SELECT id, amount FROM invoice ORDER BY amount LIMIT 2`:                           `[[12 -12345678.90] [13 0.00]]`,
+			`SELECT amount * rate, amount / 3, amount % 3, -amount FROM invoice WHERE id = 11`: `[[1.650000 6.666667 2.00 -20.00]]`,
+			`SELECT amount + 1, amount - 0.005 FROM invoice WHERE id = 11`:                     `[[21.00 19.995]]`,
+			`SELECT AVG(amount), MAX(amount) FROM invoice WHERE id <= 10`:                      `[[0.100000 0.10]]`,
+		} {
+			if got := fmt.Sprint(queryValues(t, db, sql)); got != want {
+				t.Errorf("%s: %s: got %s, want %s", when, sql, got, want)
+			}
+		}
+	}
+	check("before reopen")
+	if n := countCandidates(NewPlan(db.GetTable("invoice")), `amount > 1`); n != 1 {
+		t.Errorf("expect index range of 1 row, got %d", n)
+	}
+	if err := queryError(db, `SELECT amount / 0 FROM invoice`); err != DivisionByZeroError {
+		t.Errorf("expect division by zero, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	rows, err := db.Query(`SELECT amount, amount, amount FROM invoice WHERE id = 12`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanType := rows.ColumnTypes()[0].ScanType; scanType != reflect.TypeOf(Decimal{}) {
+		t.Errorf("got scan type %v", scanType)
+	}
+	var amount Decimal
+	var s string
+	var f float64
+	if !rows.Next() || rows.Scan(&amount, &s, &f) != nil {
+		t.Fatal(rows.Err())
+	}
+	if amount.String() != "-12345678.90" || s != "-12345678.90" || f != -12345678.9 {
+		t.Errorf("unexpected row %s %q %v", amount, s, f)
+	}
+	rows.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDropAndTruncateTable(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 10)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`DELETE FROM user WHERE id > 5`,
+		`ALTER TABLE user DROP COLUMN email`,
+		`TRUNCATE TABLE user`,
+		`INSERT INTO user (id, username) VALUES (1, "u1")`,
+		`DROP TABLE user`,
+	} {
+		if err := tx.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	if db.GetTable("user") != nil {
+		t.Fatal("table user is not dropped")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 10 {
+		t.Errorf("expect 10 rows after rollback, got %d", n)
+	}
+	if n := len(db.GetTable("user").Columns); n != 3 {
+		t.Errorf("expect 3 columns after rollback, got %d", n)
+	}
+
+	if err := db.Exec(`TRUNCATE user`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, ""); n != 0 {
+		t.Errorf("expect no row after truncate, got %d", n)
+	}
+	if err := db.Exec(`DROP TABLE user`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`DROP TABLE user`); err == nil {
+		t.Errorf("expect error for dropped table")
+	}
+	if err := db.Exec(`DROP TABLE IF EXISTS user`); err != nil {
+		t.Error(err)
+	}
+	newUserDB(t, db, 1)
+}
+
+func countCandidates(plan *Plan, where string) (n int) {
+	ast, err := (&Parser{}).ParseSelect(`SELECT * FROM user WHERE ` + where)
+	if err != nil {
+		panic(err)
+	}
+	rows, err := drain(&scanOp{plan: plan, where: ast.Where})
+	if err != nil {
+		panic(err)
+	}
+	return len(rows)
+}
+
+func TestPrimaryKeyLookup(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 100)
+	if err := db.Exec(`CREATE INDEX idx_username ON user (username)`); err != nil {
+		t.Fatal(err)
+	}
+	plan := NewPlan(db.GetTable("user"))
+
+	for _, c := range []struct {
+		where      string
+		candidates int
+		rows       int
+	}{
+		{`id = 27`, 1, 1},
+		{`id = 1000`, 0, 0},
+		{`id > 26`, 74, 74},
+		{`id > 26 AND id < 31`, 4, 4},
+		{`id > 30 AND id < 20`, 0, 0},
+		{`id < 10 AND username = "userName-5"`, 1, 1}, // index point lookup wins over a range
+		{`id = 5 AND username = "userName-6"`, 1, 0},
+		{`id > 26 OR id = 1`, 100, 75},
+	} {
+		if n := countCandidates(plan, c.where); n != c.candidates {
+			t.Errorf("%s: expect %d candidates, got %d", c.where, c.candidates, n)
+		}
+		if n := countRows(t, db, " WHERE "+c.where); n != c.rows {
+			t.Errorf("%s: expect %d rows, got %d", c.where, c.rows, n)
+		}
+	}
+
+	if ids := queryIDs(t, db, `SELECT id FROM user WHERE id > 26 LIMIT 3`); !reflect.DeepEqual(ids, []interface{}{27, 28, 29}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+}
+
+func TestNonIntegerPrimaryKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`CREATE TABLE product (sku VARCHAR(16), name TEXT, PRIMARY KEY (sku))`,
+		`CREATE TABLE stock (warehouse VARCHAR(8), sku VARCHAR(16), qty INTEGER DEFAULT 0, PRIMARY KEY (warehouse, sku))`,
+		// the first 8 bytes of the keys are the same
+		`INSERT INTO product (sku, name) VALUES ('SKU-000000002', 'b'), ('SKU-000000001', 'a'), ('B-2', 'c')`,
+		`INSERT INTO stock (warehouse, sku, qty) VALUES ('w1', 'SKU-000000001', 5), ('w1', 'B-2', 1), ('w2', 'SKU-000000001', 7)`,
+		`UPDATE product SET sku = 'A-1' WHERE sku = 'B-2'`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+
+	check := func(when string) {
+		for _, sql := range []string{
+			`INSERT INTO product (sku) VALUES ('SKU-000000001')`,
+			`INSERT INTO product (sku) VALUES ('C-3'), ('C-3')`,
+			`INSERT INTO product (sku) VALUES (NULL)`,
+			`INSERT INTO product (name) VALUES ('d')`,
+			`UPDATE product SET sku = 'A-1' WHERE sku = 'SKU-000000002'`,
+			`INSERT INTO stock (warehouse, sku) VALUES ('w2', 'SKU-000000001')`,
+			`INSERT INTO stock (warehouse, qty) VALUES ('w3', 1)`,
+		} {
+			if err := db.Exec(sql); err == nil {
+				t.Errorf("%s: %s: expect error", when, sql)
+			}
+		}
+		for sql, want := range map[string][][]interface{}{
+			`SELECT sku, name FROM product ORDER BY sku`:                                             {{"A-1", "c"}, {"SKU-000000001", "a"}, {"SKU-000000002", "b"}},
+			`SELECT name FROM product WHERE sku = 'SKU-000000001'`:                                   {{"a"}},
+			`SELECT sku FROM product WHERE sku > 'B' ORDER BY sku DESC`:                              {{"SKU-000000002"}, {"SKU-000000001"}},
+			`SELECT qty FROM stock WHERE warehouse = 'w1' AND sku = 'SKU-000000001'`:                 {{5}},
+			`SELECT s.warehouse, p.name FROM stock s JOIN product p ON p.sku = s.sku ORDER BY s.qty`: {{"w1", "a"}, {"w2", "a"}},
+		} {
+			if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: got %v, want %v", when, sql, got, want)
+			}
+		}
+		for where, want := range map[string]int{
+			`sku = 'SKU-000000001'`: 1,
+			`sku >= 'SKU'`:          2,
+		} {
+			if n := countCandidates(NewPlan(db.GetTable("product")), where); n != want {
+				t.Errorf("%s: %s: expect %d candidates, got %d", when, where, want, n)
+			}
+		}
+		if n := countCandidates(NewPlan(db.GetTable("stock")), `warehouse = 'w1'`); n != 2 {
+			t.Errorf("%s: expect 2 candidates by the first key column, got %d", when, n)
+		}
+	}
+	check("before reopen")
+
+	if err := db.Exec(`ALTER TABLE stock DROP COLUMN sku`); err == nil {
+		t.Errorf("expect error when dropping a column of the primary key")
+	}
+	for _, sql := range []string{
+		`ALTER TABLE stock RENAME COLUMN warehouse TO place`,
+		`ALTER TABLE stock ADD COLUMN note TEXT`,
+		`DELETE FROM product WHERE sku = 'A-1'`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+	if err := db.Exec(`INSERT INTO stock (place, sku) VALUES ('w1', 'B-2')`); err == nil {
+		t.Errorf("expect duplicate key after ALTER TABLE")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO stock (place, sku) VALUES ('w2', 'SKU-000000001')`); err == nil {
+		t.Errorf("expect duplicate key after reopen")
+	}
+	if err := db.Exec(`INSERT INTO product (sku, name) VALUES ('A-1', 'e')`); err != nil {
+		t.Errorf("reinsert a deleted key: %s", err)
+	}
+	if got := queryValues(t, db, `SELECT place, sku FROM stock WHERE place = 'w2'`); !reflect.DeepEqual(got, [][]interface{}{{"w2", "SKU-000000001"}}) {
+		t.Errorf("unexpected rows %v", got)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queryIDs returns the id of every row of a query which selects only id
+func queryIDs(t *testing.T, db *DB, sql string) (ids []interface{}) {
+	for _, row := range queryValues(t, db, sql) {
+		ids = append(ids, row[0])
+	}
+	return ids
+}
+
+func TestOrderBy(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 100)
+	if err := db.Exec(`CREATE INDEX idx_username ON user (username)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`UPDATE user SET email = "same" WHERE id > 10 AND id < 15`); err != nil {
+		t.Fatal(err)
+	}
+
+	for sql, want := range map[string][]interface{}{
+		`SELECT id FROM user ORDER BY id DESC LIMIT 3`:                               {100, 99, 98},
+		`SELECT id FROM user WHERE id < 4 ORDER BY id ASC`:                           {1, 2, 3},
+		`SELECT id FROM user WHERE id > 95 ORDER BY id DESC`:                         {100, 99, 98, 97, 96},
+		`SELECT id FROM user WHERE id >= 50 AND id <= 52 ORDER BY id DESC, username`: {52, 51, 50},
+		`SELECT id FROM user WHERE username >= "userName-97" ORDER BY id DESC`:       {99, 98, 97},
+		`SELECT id FROM user ORDER BY username LIMIT 4`:                              {1, 10, 100, 11},
+		`SELECT id FROM user ORDER BY username DESC LIMIT 2`:                         {99, 98},
+		`SELECT id FROM user WHERE id < 20 ORDER BY email DESC, id DESC LIMIT 6`:     {14, 13, 12, 11, 9, 8},
+		`SELECT id FROM user WHERE id < 20 ORDER BY email = "same", id LIMIT 3`:      {1, 2, 3},
+		`SELECT id FROM user ORDER BY id % 10, id DESC LIMIT 3`:                      {100, 90, 80},
+		`SELECT id FROM user WHERE id > 10 AND id < 15 ORDER BY email, id DESC;`:     {14, 13, 12, 11},
+		`SELECT id FROM user WHERE id > 10 AND id < 15 ORDER BY user.email, user.id`: {11, 12, 13, 14},
+		// a position is the projection at it
+		`SELECT id FROM user ORDER BY 1 DESC LIMIT 3`:                                           {100, 99, 98},
+		`SELECT id, email FROM user WHERE id < 20 ORDER BY 2 DESC, 1 LIMIT 6`:                   {11, 12, 13, 14, 9, 8},
+		`SELECT id, id % 10 FROM user WHERE id > 95 ORDER BY 2 DESC`:                            {99, 98, 97, 96, 100},
+		`SELECT email FROM user WHERE id < 20 GROUP BY email ORDER BY COUNT(*) DESC, 1 LIMIT 2`: {"same", "User-10@gmail.com"},
+		`SELECT * FROM user a JOIN user b ON b.id = a.id WHERE a.id < 4 ORDER BY 6 DESC`:        {"User-3@gmail.com", "User-2@gmail.com", "User-1@gmail.com"},
+	} {
+		if got := queryIDs(t, db, sql); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", sql, got, want)
+		}
+	}
+
+	for _, sql := range []string{
+		`SELECT id FROM user ORDER BY nosuch`,
+		`SELECT id FROM user ORDER BY`,
+		`SELECT id FROM user ORDER id`,
+		`SELECT id FROM user ORDER BY id DESC id`,
+		`SELECT id FROM user ORDER BY id + "a"`,
+		`SELECT id FROM user ORDER BY 0`,
+		`SELECT id FROM user ORDER BY 2`,
+	} {
+		if err := queryError(db, sql); err == nil {
+			t.Errorf("%s: expect error, got nil", sql)
+		}
+	}
+	if err := db.Exec(`DELETE FROM user WHERE id > 1 ORDER BY id`); err == nil {
+		t.Errorf("expect error for ORDER BY in DELETE")
+	}
+}
+
+// queryError returns the error of a query, which may be found while reading the rows
+func queryError(db *DB, sql string) error {
+	rows, err := db.Query(sql)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func queryValues(t *testing.T, db *DB, sql string) (rows [][]interface{}) {
+	result, err := db.Query(sql)
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err)
+	}
+	for result.Next() {
+		rows = append(rows, result.Values())
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("%s: %s", sql, err)
+	}
+	return rows
+}
+
+func TestAggregate(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 100)
+	if err := db.Exec(`UPDATE user SET email = "same" WHERE id > 10 AND id < 15`); err != nil {
+		t.Fatal(err)
+	}
+
+	type rows = [][]interface{}
+	for sql, want := range map[string]rows{
+		`SELECT COUNT(*) FROM user`: {{100}},
+		`SELECT count(*), SUM(id), MIN(id), MAX(id), AVG(id) FROM user WHERE id <= 10`:       {{10, 55, 1, 10, 5.5}},
+		`SELECT COUNT(*), SUM(id), MIN(id), AVG(id) FROM user WHERE id > 1000`:               {{0, nil, nil, nil}},
+		`SELECT MAX(username), COUNT(*) + 1 FROM user`:                                       {{"userName-99", 101}},
+		`SELECT id % 3, COUNT(*) FROM user GROUP BY id % 3 ORDER BY id % 3`:                  {{0, 33}, {1, 34}, {2, 33}},
+		`SELECT COUNT(*) FROM user GROUP BY id % 3 HAVING COUNT(*) > 33`:                     {{34}},
+		`SELECT email, COUNT(*) FROM user GROUP BY email HAVING COUNT(*) > 1`:                {{"same", 4}},
+		`SELECT user.email, MIN(id) FROM user GROUP BY email ORDER BY COUNT(*) DESC LIMIT 1`: {{"same", 11}},
+		`SELECT COUNT(*) FROM user WHERE id > 1000 GROUP BY email`:                           nil,
+		`SELECT COUNT(*) FROM user HAVING MAX(id) > 1000`:                                    nil,
+		`SELECT SUM(id * 2), SUM(id) / COUNT(id) FROM user WHERE id < 4`:                     {{12, 2}},
+	} {
+		if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", sql, got, want)
+		}
+	}
+
+	for _, sql := range []string{
+		`SELECT username, COUNT(*) FROM user`,
+		`SELECT COUNT(*) FROM user WHERE COUNT(*) > 1`,
+		`SELECT SUM(username) FROM user`,
+		`SELECT SUM(COUNT(*)) FROM user`,
+		`SELECT SUM(*) FROM user`,
+		`SELECT * FROM user GROUP BY id`,
+		`SELECT COUNT(nosuch) FROM user`,
+		`SELECT FOO(id) FROM user`,
+		`SELECT COUNT(*) FROM user GROUP BY`,
+		`SELECT COUNT(*) FROM user GROUP BY COUNT(*)`,
+	} {
+		if err := queryError(db, sql); err == nil {
+			t.Errorf("%s: expect error, got nil", sql)
+		}
+	}
+}
+
+func TestLimitDoesNotLeakGoroutines(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 2000) // more rows than the old 512 buffered channel
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		if n := countRows(t, db, ` LIMIT 3`); n != 3 {
+			t.Fatalf("expect 3 rows, got %d", n)
+		}
+		for range db.GetTable("user").GetClusterIndex().GetAllItems() {
+			break
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("goroutines grow from %d to %d", before, after)
+	}
+
+	// writers are not blocked by abandoned scans
+	if err := db.Exec(`DELETE FROM user WHERE id > 10`); err != nil {
+		t.Fatal(err)
+	}
+}
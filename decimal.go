@@ -0,0 +1,216 @@
+package sqlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// divisionScale is the number of digits a quotient of decimals has more than the dividend
+const divisionScale = 4
+
+/*
+Decimal is the exact value of a DECIMAL(p,s) or NUMERIC(p,s) column, it is
+unscaled / 10^scale. Addition, subtraction, multiplication and SUM of
+decimals are exact, division rounds half away from zero to divisionScale
+digits more than the dividend has. An int or a float64 in arithmetic with a
+decimal becomes a decimal, a float64 by its shortest representation, so
+that price * 1.1 is exact.
+*/
+type Decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+var bigTen = big.NewInt(10)
+
+// ParseDecimal parses [+-]digits[.digits], eg. 12.50 or -0.5
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	digits, scale := s, 0
+	if dot := strings.IndexByte(s, '.'); dot != -1 {
+		digits, scale = s[:dot]+s[dot+1:], len(s)-dot-1
+	}
+	unsigned := strings.TrimLeft(digits, "+-")
+	if len(digits)-len(unsigned) > 1 || unsigned == "" || strings.Trim(unsigned, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("%q %s", s, IsNotDecimalError)
+	}
+	unscaled, _ := new(big.Int).SetString(digits, 10)
+	return Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+// toDecimal converts a decimal, an int or a float64 to a decimal
+func toDecimal(v interface{}) (Decimal, bool) {
+	switch v := v.(type) {
+	case Decimal:
+		return v, true
+	case int:
+		return Decimal{unscaled: big.NewInt(int64(v))}, true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Decimal{}, false
+		}
+		d, err := ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+		return d, err == nil
+	}
+	return Decimal{}, false
+}
+
+// decimalValue converts a value stored into a DECIMAL column, a string is parsed too
+func decimalValue(v interface{}) (Decimal, bool) {
+	if s, ok := v.(string); ok {
+		d, err := ParseDecimal(s)
+		return d, err == nil
+	}
+	return toDecimal(v)
+}
+
+func (d Decimal) int() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// quo divides rounding half away from zero
+func quo(x, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	if r.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(y)) >= 0 {
+		if x.Sign() == y.Sign() {
+			q.Add(q, big.NewInt(1))
+		} else {
+			q.Sub(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// rescale returns d with scale digits after the point, it rounds half away from zero
+func (d Decimal) rescale(scale int) Decimal {
+	switch {
+	case scale > d.scale:
+		return Decimal{unscaled: new(big.Int).Mul(d.int(), pow10(scale-d.scale)), scale: scale}
+	case scale < d.scale:
+		return Decimal{unscaled: quo(d.int(), pow10(d.scale-scale)), scale: scale}
+	}
+	return d
+}
+
+// precision is the number of digits, leading zeros are not counted
+func (d Decimal) precision() int {
+	if d.int().Sign() == 0 {
+		return 0
+	}
+	return len(new(big.Int).Abs(d.int()).String())
+}
+
+// normalize removes the trailing zeros after the point
+func (d Decimal) normalize() Decimal {
+	r := new(big.Int)
+	for d.scale > 0 {
+		q, _ := new(big.Int).QuoRem(d.int(), bigTen, r)
+		if r.Sign() != 0 {
+			break
+		}
+		d = Decimal{unscaled: q, scale: d.scale - 1}
+	}
+	return d
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.int().Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// Cmp returns -1, 0 or 1 if d is less than, equal to or greater than e
+func (d Decimal) Cmp(e Decimal) int {
+	scale := maxScale(d, e)
+	return d.rescale(scale).int().Cmp(e.rescale(scale).int())
+}
+
+func maxScale(x, y Decimal) int {
+	if x.scale > y.scale {
+		return x.scale
+	}
+	return y.scale
+}
+
+func (d Decimal) neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Scan implements sql.Scanner, so that a DECIMAL column can be scanned into a Decimal by database/sql
+func (d *Decimal) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return d.Scan(string(src))
+	case string:
+		v, err := ParseDecimal(src)
+		if err == nil {
+			*d = v
+		}
+		return err
+	case int64:
+		return d.Scan(int(src))
+	}
+	v, ok := toDecimal(src)
+	if !ok {
+		return fmt.Errorf("converting %T to Decimal is unsupported", src)
+	}
+	*d = v
+	return nil
+}
+
+// Value implements driver.Valuer, a Decimal argument is passed as its string
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// decimalArithmetic is the arithmetic of decimals, an int or a float64 is converted to a decimal
+func decimalArithmetic(op string, l, r interface{}) (interface{}, error) {
+	x, xOk := toDecimal(l)
+	y, yOk := toDecimal(r)
+	if !xOk || !yOk {
+		return nil, fmt.Errorf("can not %s %T and %T", op, l, r)
+	}
+	scale := maxScale(x, y)
+	switch op {
+	case "+":
+		return Decimal{unscaled: new(big.Int).Add(x.rescale(scale).int(), y.rescale(scale).int()), scale: scale}, nil
+	case "-":
+		return Decimal{unscaled: new(big.Int).Sub(x.rescale(scale).int(), y.rescale(scale).int()), scale: scale}, nil
+	case "*":
+		return Decimal{unscaled: new(big.Int).Mul(x.int(), y.int()), scale: x.scale + y.scale}, nil
+	}
+	if y.int().Sign() == 0 {
+		return nil, DivisionByZeroError
+	}
+	if op == "%" {
+		return Decimal{unscaled: new(big.Int).Rem(x.rescale(scale).int(), y.rescale(scale).int()), scale: scale}, nil
+	}
+	// the quotient is unscaled by 10^(x.scale + divisionScale)
+	scale = x.scale + divisionScale
+	num := new(big.Int).Mul(x.int(), pow10(divisionScale+y.scale))
+	return Decimal{unscaled: quo(num, y.int()), scale: scale}, nil
+}
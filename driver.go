@@ -0,0 +1,302 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// DriverName is the name the driver is registered with in database/sql
+const DriverName = "sqlite-toy"
+
+// MemoryDSN opens an in-memory database
+const MemoryDSN = ":memory:"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+/*
+Driver makes the engine usable through database/sql:
+
+	db, err := sql.Open("sqlite-toy", "file.db") // or ":memory:"
+
+All the connections of a sql.DB share one engine DB, it is opened by the first
+connection and closed by sql.DB.Close. The engine has one writer at a time,
+a statement or a query run outside of a sql.Tx waits until the running sql.Tx
+is finished, so it never sees uncommitted rows. A query reads its rows before
+it returns, so the open rows never block a writer.
+BEGIN, COMMIT and ROLLBACK are not allowed as statements, use sql.DB.Begin.
+The parameters of a statement are ? or $n, see Stmt.
+*/
+type Driver struct{}
+
+// Open returns a connection to its own DB, which is closed with the connection
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	db, err := openDSN(name)
+	if err != nil {
+		return nil, err
+	}
+	return &driverConn{db: db, own: true}, nil
+}
+
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	return &connector{driver: d, name: name}, nil
+}
+
+func openDSN(name string) (*DB, error) {
+	if name == MemoryDSN || name == "" {
+		return NewDB(), nil
+	}
+	return Open(name)
+}
+
+type connector struct {
+	driver *Driver
+	name   string
+
+	lock sync.Mutex
+	db   *DB
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.db == nil {
+		db, err := openDSN(c.name)
+		if err != nil {
+			return nil, err
+		}
+		c.db = db
+	}
+	return &driverConn{db: c.db}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close is called by sql.DB.Close
+func (c *connector) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.db == nil {
+		return nil
+	}
+	err := c.db.Close()
+	c.db = nil
+	return err
+}
+
+type driverConn struct {
+	db  *DB
+	tx  *Tx  // the running sql.Tx of the connection
+	own bool // db is closed with the connection
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &driverStmt{conn: c, stmt: stmt}, nil
+}
+
+func (c *driverConn) Close() error {
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	if c.own {
+		return c.db.Close()
+	}
+	return nil
+}
+
+func (c *driverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *driverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, TxInProgressError
+	}
+	// a transaction runs alone, neither writers nor readers run beside it, so it is serializable
+	if level := sql.IsolationLevel(opts.Isolation); level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, fmt.Errorf("isolation level %s is not supported", level)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return &driverTx{conn: c}, nil
+}
+
+func (c *driverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.exec(stmt, values)
+}
+
+func (c *driverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(stmt, values)
+}
+
+// namedValues returns the arguments in order, the parameters have no names
+func namedValues(args []driver.NamedValue) ([]interface{}, error) {
+	values := make([]interface{}, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named parameter %s is not supported, use ? or $n", arg.Name)
+		}
+		values[arg.Ordinal-1] = arg.Value
+	}
+	return values, nil
+}
+
+func (c *driverConn) exec(stmt *Stmt, args []interface{}) (driver.Result, error) {
+	switch stmt.Type {
+	case BEGIN, COMMIT, ROLLBACK:
+		return nil, fmt.Errorf("use the transactions of database/sql instead of BEGIN, COMMIT and ROLLBACK")
+	}
+	var affected int64
+	var err error
+	if c.tx != nil {
+		affected, err = c.tx.run(stmt, args)
+	} else {
+		affected, err = stmt.execute(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return driverResult(affected), nil
+}
+
+func (c *driverConn) query(stmt *Stmt, args []interface{}) (driver.Rows, error) {
+	var rows *Rows
+	var err error
+	if c.tx != nil {
+		rows, err = c.tx.query(stmt, args)
+	} else {
+		rows, err = stmt.Query(args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &driverRows{rows: rows}, nil
+}
+
+type driverStmt struct {
+	conn *driverConn
+	stmt *Stmt
+}
+
+func (s *driverStmt) Close() error { return nil }
+
+func (s *driverStmt) NumInput() int { return s.stmt.NumInput() }
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.exec(s.stmt, values(args))
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.query(s.stmt, values(args))
+}
+
+func values(args []driver.Value) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return values
+}
+
+type driverTx struct {
+	conn *driverConn
+}
+
+func (t *driverTx) Commit() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+	return tx.Commit()
+}
+
+func (t *driverTx) Rollback() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+	return tx.Rollback()
+}
+
+// driverResult is the number of rows changed by a statement
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("LastInsertId is not supported")
+}
+
+func (r driverResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+type driverRows struct {
+	rows *Rows
+}
+
+func (r *driverRows) Columns() []string { return r.rows.Columns() }
+
+func (r *driverRows) Close() error { return r.rows.Close() }
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.rows.row {
+		switch n := v.(type) {
+		case int:
+			v = int64(n) // driver.Value has no int
+		case Decimal:
+			v = n.String() // nor a decimal, it is scanned into a Decimal by Decimal.Scan
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+func (r *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.rows.columns[index].DatabaseType
+}
+
+func (r *driverRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.rows.columns[index].ScanType
+}
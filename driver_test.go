@@ -0,0 +1,292 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDriver(t *testing.T) {
+	db, err := sql.Open(DriverName, MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+
+	if _, err := db.Exec(createUserSQL); err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.Exec(`INSERT INTO user (id, username) VALUES (1, "a"), (2, "b"), (3, "c")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("insert: expect 3 rows affected, got %d %v", n, err)
+	}
+	res, err = db.Exec(`UPDATE user SET email = "x" WHERE id > 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("update: expect 2 rows affected, got %d %v", n, err)
+	}
+
+	// every connection of the pool sees the same tables
+	var count int64
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user WHERE email = "x"`).Scan(&count); err != nil || count != 2 {
+		t.Errorf("expect 2, got %d %v", count, err)
+	}
+
+	rows, err := db.Query(`SELECT username, id FROM user ORDER BY id DESC`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types[0].Name() != "username" || types[0].DatabaseTypeName() != "VARCHAR(16)" || types[1].ScanType() != reflect.TypeOf(0) {
+		t.Errorf("unexpected column types %v %v %v", types[0].Name(), types[0].DatabaseTypeName(), types[1].ScanType())
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		var id int
+		if err := rows.Scan(&name, &id); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"c", "b", "a"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	// errors found while reading rows
+	rows, err = db.Query(`SELECT id / 0 FROM user`)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err == nil {
+		t.Errorf("expect division by zero")
+	}
+
+	for _, query := range []string{`BEGIN`, `COMMIT`, `SELECT * FROM nosuch`} {
+		if _, err := db.Exec(query); err == nil {
+			t.Errorf("%s: expect error", query)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM user WHERE id = 1`, 1); err == nil {
+		t.Errorf("expect error for arguments")
+	}
+
+	// parameters
+	res, err = db.Exec(`UPDATE user SET username = ? WHERE id = ?`, `it's "d"`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Errorf("expect 1 row updated, got %d", n)
+	}
+	stmt, err := db.Prepare(`SELECT username FROM user WHERE id = $1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	var name string
+	if err := stmt.QueryRow(1).Scan(&name); err != nil || name != `it's "d"` {
+		t.Errorf("expect it's \"d\", got %q %v", name, err)
+	}
+	if _, err := db.Exec(`DELETE FROM user WHERE id = ?`, sql.Named("id", 1)); err == nil {
+		t.Errorf("expect error for named argument")
+	}
+}
+
+func TestDriverDecimal(t *testing.T) {
+	db, err := sql.Open(DriverName, MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE price (id INTEGER NOT NULL, amount DECIMAL(8,2), PRIMARY KEY (id))`); err != nil {
+		t.Fatal(err)
+	}
+	amount, err := ParseDecimal("0.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 3; id++ {
+		if _, err := db.Exec(`INSERT INTO price (id, amount) VALUES (?, ?)`, id, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sum Decimal
+	var f float64
+	if err := db.QueryRow(`SELECT SUM(amount), SUM(amount) FROM price`).Scan(&sum, &f); err != nil {
+		t.Fatal(err)
+	}
+	if sum.String() != "0.30" || f != 0.3 {
+		t.Errorf("expect 0.30, got %s %v", sum, f)
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(DriverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(createUserSQL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO user (id, username) VALUES (1, "a"), (2, "b")`); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tx.Exec(`DELETE FROM user WHERE id > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("expect 2 rows deleted, got %d", n)
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM user`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("expect 0 rows in tx, got %d %v", count, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO user (id, username) VALUES (3, "c")`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopened from the file
+	db, err = sql.Open(DriverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user`).Scan(&count); err != nil || count != 3 {
+		t.Errorf("expect 3 rows, got %d %v", count, err)
+	}
+}
+
+// TestDriverConcurrent is meant to be run with -race too
+func TestDriverConcurrent(t *testing.T) {
+	db, err := sql.Open(DriverName, MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(createUserSQL); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, inserts = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers+1)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= inserts; i++ {
+				if _, err := db.Exec(`INSERT INTO user (id, username) VALUES (?, "w")`, w*inserts+i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for i := 0; i < inserts; i++ {
+				var count int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM user WHERE username = "w"`).Scan(&count); err != nil {
+					errs <- err
+					return
+				}
+				if count < last {
+					errs <- fmt.Errorf("count goes back from %d to %d", last, count)
+					return
+				}
+				last = count
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		// DDL changes the indexes while they are read
+		defer wg.Done()
+		for i := 0; i < inserts; i++ {
+			if _, err := db.Exec(fmt.Sprintf(`CREATE INDEX idx_%d ON user (username)`, i)); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// a query outside of a sql.Tx waits for it and does not see its rows
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO user (id, username) VALUES (1000, "uncommitted")`); err != nil {
+		t.Fatal(err)
+	}
+	counted := make(chan int, 1)
+	go func() {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM user WHERE username = "uncommitted"`).Scan(&count); err != nil {
+			t.Error(err)
+		}
+		counted <- count
+	}()
+	select {
+	case count := <-counted:
+		t.Fatalf("query does not wait for the transaction, counted %d", count)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if count := <-counted; count != 0 {
+		t.Errorf("expect the rolled back row is not seen, got %d", count)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user`).Scan(&count); err != nil || count != writers*inserts {
+		t.Errorf("expect %d rows, got %d %v", writers*inserts, count, err)
+	}
+}
@@ -0,0 +1,379 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var DivisionByZeroError = fmt.Errorf("division by zero")
+
+/*
+evalFunc is a compiled expression, it evaluates the expression against a
+row without parsing or formatting anything. A nil result is the SQL NULL,
+so comparisons with NULL are NULL and AND/OR use three valued logic.
+Only IS [NOT] NULL tells whether a value is NULL.
+*/
+type evalFunc func(row []interface{}) (interface{}, error)
+
+// columnResolver returns the position of a column in the rows passed to the evalFunc
+type columnResolver func(col *ColumnRef) (int, error)
+
+// tableResolver resolves the columns of a single table
+func (t *Table) tableResolver(col *ColumnRef) (int, error) {
+	if col.Table != "" && col.Table != strings.ToLower(t.Name) {
+		return -1, HasNotColumnError
+	}
+	for idx, c := range t.Columns {
+		if strings.ToLower(c) == col.Name {
+			return idx, nil
+		}
+	}
+	return -1, HasNotColumnError
+}
+
+func compileExpr(e Expr, resolve columnResolver) (evalFunc, error) {
+	switch e := e.(type) {
+	case *Literal:
+		val := e.Val
+		return func([]interface{}) (interface{}, error) { return val, nil }, nil
+	case *ColumnRef:
+		idx, err := resolve(e)
+		if err != nil && e.Quoted != nil {
+			return compileExpr(e.Quoted, resolve)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) (interface{}, error) { return row[idx], nil }, nil
+	case *UnaryExpr:
+		operand, err := compileExpr(e.Expr, resolve)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "NOT" {
+			return compileNot(operand), nil
+		}
+		return compileNegate(operand), nil
+	case *IsNull:
+		operand, err := compileExpr(e.Expr, resolve)
+		if err != nil {
+			return nil, err
+		}
+		not := e.Not
+		return func(row []interface{}) (interface{}, error) {
+			v, err := operand(row)
+			if err != nil {
+				return nil, err
+			}
+			return (v == nil) != not, nil
+		}, nil
+	case *BinaryExpr:
+		left, err := compileExpr(e.Left, resolve)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileExpr(e.Right, resolve)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case "AND":
+			return compileAnd(left, right), nil
+		case "OR":
+			return compileOr(left, right), nil
+		case "+", "-", "*", "/", "%":
+			return compileArithmetic(e.Op, left, right), nil
+		default:
+			return compileComparison(e.Op, left, right)
+		}
+	case *Param:
+		return nil, fmt.Errorf("parameter %s is not bound", e)
+	case *FuncCall:
+		if aggregateFuncs[e.Name] {
+			// aggregates are replaced by the aggregate operator before compiling
+			return nil, fmt.Errorf("misuse of aggregate function %s", e)
+		}
+		return compileCall(e, resolve)
+	case *IntervalExpr:
+		return compileInterval(e, resolve)
+	}
+	return nil, fmt.Errorf("unsupported expression %v", e)
+}
+
+// scalarFunc is a function of a row, it returns NULL if any argument is NULL
+type scalarFunc struct {
+	args int
+	fn   func(args []interface{}) (interface{}, error)
+}
+
+var scalarFuncs = map[string]*scalarFunc{
+	"DATE":              timeFunc("DATE", "DATE"),
+	"TIME":              timeFunc("TIME", "TIME"),
+	"DATETIME":          timeFunc("DATETIME", "TIMESTAMP"),
+	"STRFTIME":          {args: 2, fn: strftime},
+	"DATE_ADD":          dateAdd("DATE_ADD", "+"),
+	"DATE_SUB":          dateAdd("DATE_SUB", "-"),
+	"CURRENT_DATE":      currentTimeFunc("DATE"),
+	"CURRENT_TIME":      currentTimeFunc("TIME"),
+	"CURRENT_TIMESTAMP": currentTimeFunc("TIMESTAMP"),
+}
+
+func compileCall(e *FuncCall, resolve columnResolver) (evalFunc, error) {
+	f := scalarFuncs[e.Name]
+	if f == nil {
+		return nil, fmt.Errorf("no such function %s", e.Name)
+	}
+	if e.Star || len(e.Args) != f.args {
+		return nil, fmt.Errorf("%s takes %d arguments", e.Name, f.args)
+	}
+	args := make([]evalFunc, len(e.Args))
+	for i, arg := range e.Args {
+		eval, err := compileExpr(arg, resolve)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = eval
+	}
+	return func(row []interface{}) (interface{}, error) {
+		vals := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(row)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		return f.fn(vals)
+	}, nil
+}
+
+func toBool(v interface{}) (b interface{}, err error) {
+	switch v.(type) {
+	case nil, bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%v is not a boolean", v)
+}
+
+func compileNot(operand evalFunc) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		v, err := operand(row)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = toBool(v); v == nil || err != nil {
+			return nil, err
+		}
+		return !v.(bool), nil
+	}
+}
+
+// compileAnd: FALSE AND x is FALSE, NULL AND TRUE is NULL
+func compileAnd(left, right evalFunc) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		l, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		if l, err = toBool(l); err != nil || l == false {
+			return l, err
+		}
+		r, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = toBool(r); err != nil || r == false {
+			return r, err
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	}
+}
+
+// compileOr: TRUE OR x is TRUE, NULL OR FALSE is NULL
+func compileOr(left, right evalFunc) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		l, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		if l, err = toBool(l); err != nil || l == true {
+			return l, err
+		}
+		r, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = toBool(r); err != nil || r == true {
+			return r, err
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+}
+
+func compileComparison(op string, left, right evalFunc) (evalFunc, error) {
+	var test func(c int) bool
+	switch op {
+	case "=":
+		test = func(c int) bool { return c == 0 }
+	case "!=":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	case ">=":
+		test = func(c int) bool { return c >= 0 }
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+	return func(row []interface{}) (interface{}, error) {
+		l, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		c, ok := compareValue(l, r)
+		if !ok {
+			return nil, fmt.Errorf("can not compare %T with %T", l, r)
+		}
+		return test(c), nil
+	}, nil
+}
+
+func compileNegate(operand evalFunc) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		v, err := operand(row)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case nil:
+			return nil, nil
+		case int:
+			return -v, nil
+		case float64:
+			return -v, nil
+		case Decimal:
+			return v.neg(), nil
+		}
+		return nil, fmt.Errorf("can not negate %T", v)
+	}
+}
+
+func compileArithmetic(op string, left, right evalFunc) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		l, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return arithmetic(op, l, r)
+	}
+}
+
+// arithmetic on int stays int, it becomes float64 if either side is float64
+func arithmetic(op string, l, r interface{}) (interface{}, error) {
+	switch l.(type) {
+	case time.Time, interval:
+		return timeArithmetic(op, l, r)
+	}
+	_, xIsDecimal := l.(Decimal)
+	_, yIsDecimal := r.(Decimal)
+	if xIsDecimal || yIsDecimal {
+		return decimalArithmetic(op, l, r)
+	}
+	x, xIsInt := l.(int)
+	y, yIsInt := r.(int)
+	if xIsInt && yIsInt {
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		}
+		if y == 0 {
+			return nil, DivisionByZeroError
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	}
+
+	fx, xOk := toFloat(l)
+	fy, yOk := toFloat(r)
+	if !xOk || !yOk || op == "%" {
+		return nil, fmt.Errorf("can not %s %T and %T", op, l, r)
+	}
+	switch op {
+	case "+":
+		return fx + fy, nil
+	case "-":
+		return fx - fy, nil
+	case "*":
+		return fx * fy, nil
+	}
+	if fy == 0 {
+		return nil, DivisionByZeroError
+	}
+	return fx / fy, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case Decimal:
+		return v.Float64(), true
+	}
+	return 0, false
+}
+
+// rowFilter reports whether the row passes WHERE, ON or HAVING
+type rowFilter func(row []interface{}) (bool, error)
+
+// compileFilter compiles WHERE, a row passes only if WHERE is TRUE, not if it is FALSE or NULL.
+func compileFilter(where Expr, resolve columnResolver) (rowFilter, error) {
+	if where == nil {
+		return func([]interface{}) (bool, error) { return true, nil }, nil
+	}
+	eval, err := compileExpr(where, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []interface{}) (bool, error) {
+		v, err := eval(row)
+		if err != nil {
+			return false, err
+		}
+		if v, err = toBool(v); err != nil {
+			return false, fmt.Errorf("WHERE %s: %s", where, err)
+		}
+		return v == true, nil
+	}, nil
+}
@@ -0,0 +1,181 @@
+package sqlite
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWhereExpr(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 100)
+
+	for where, want := range map[string]int{
+		` WHERE id >= 90`:    11,
+		` WHERE id <= 10`:    10,
+		` WHERE id != 1`:     99,
+		` WHERE id <> 1`:     99,
+		` WHERE id == 1`:     1,
+		` WHERE 10 >= id`:    10,
+		` WHERE NOT id > 10`: 10,
+		` WHERE (id < 5 OR id > 95) AND id % 2 = 0`:         5,
+		` WHERE id * 2 + 1 = 21`:                            1,
+		` WHERE -id < -98`:                                  2,
+		` WHERE id / 3 = 1`:                                 3,
+		` WHERE id > 5.5 AND id < 8.5`:                      3,
+		` WHERE username = "userName-7"`:                    1,
+		` WHERE username >= "userName-9"`:                   11,
+		` WHERE user.username = 'x' OR user.id = 3`:         1,
+		` WHERE id = NULL`:                                  0,
+		` WHERE NOT id = NULL`:                              0,
+		` WHERE id = NULL OR id = 3`:                        1,
+		` WHERE id = NULL AND id = 3`:                       0,
+		` WHERE TRUE`:                                       100,
+		` WHERE id > 50 AND NOT (username = "userName-60")`: 49,
+	} {
+		if got := countRows(t, db, where); got != want {
+			t.Errorf("%s got %d rows, want %d", where, got, want)
+		}
+	}
+
+	for _, where := range []string{
+		` WHERE id = "1"`,      // incomparable types
+		` WHERE id`,            // not a boolean
+		` WHERE id / 0 = 1`,    // division by zero
+		` WHERE len = 1`,       // no such column, even if it is a Go builtin
+		` WHERE other.id = 1`,  // other table
+		` WHERE id = 1 AND`,    // syntax
+		` WHERE (id = 1`,       // syntax
+		` WHERE id = 1 id = 2`, // syntax
+	} {
+		if err := queryError(db, `SELECT * FROM user`+where); err == nil {
+			t.Errorf("%s expect error, got nil", where)
+		}
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	for sql, want := range map[string]string{
+		`a = 1 AND b = 2 OR c = 3`:   `(((a = 1) AND (b = 2)) OR (c = 3))`,
+		`a = 1 AND (b = 2 OR c = 3)`: `((a = 1) AND ((b = 2) OR (c = 3)))`,
+		`NOT a = 1 AND b`:            `(NOT (a = 1) AND b)`,
+		`a + b * c >= -1`:            `((a + (b * c)) >= -1)`,
+		`a - -b <> "x"`:              `((a - -b) != "x")`,
+		`t.a <= NULL`:                `(t.a <= NULL)`,
+	} {
+		p := &Parser{}
+		ast, err := p.ParseSelect(`SELECT * FROM t WHERE ` + sql)
+		if err != nil {
+			t.Errorf("%s: %s", sql, err)
+			continue
+		}
+		if got := ast.Where.String(); got != want {
+			t.Errorf("%s parsed as %s, want %s", sql, got, want)
+		}
+	}
+}
+
+// legacyIsRowFiltered is the former WHERE filter, which formats the row
+// into a Go expression and evaluates it with go/types, kept to benchmark against.
+func legacyIsRowFiltered(table *Table, where []string, row *BPItem) (filtered bool, err error) {
+	var (
+		normalized = make([]string, len(where))
+		tv         types.TypeAndValue
+	)
+
+	var cols []string
+	for _, col := range table.Columns {
+		cols = append(cols, strings.ToLower(col))
+	}
+
+Loop:
+	for i, w := range where {
+		switch upper := strings.ToLower(w); upper {
+		case AND:
+			normalized[i] = "&&"
+			continue
+		case OR:
+			normalized[i] = "||"
+			continue
+		case "=":
+			normalized[i] = "=="
+			continue
+		}
+
+		for idx, col := range cols {
+			if col == strings.ToLower(w) {
+				value := row.Val.([]interface{})
+				val := value[idx]
+				rt := reflect.TypeOf(val)
+				switch rt.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+					normalized[i] = fmt.Sprintf("%d", val)
+				case reflect.Bool:
+					normalized[i] = fmt.Sprintf("%t", val)
+				case reflect.String:
+					normalized[i] = strconv.Quote(fmt.Sprintf("%s", val))
+				default:
+					normalized[i] = fmt.Sprintf("%v", val)
+				}
+				continue Loop
+			}
+		}
+
+		normalized[i] = w
+	}
+
+	expr := strings.Join(normalized, " ")
+	fSet := token.NewFileSet()
+	if tv, err = types.Eval(fSet, nil, token.NoPos, expr); err != nil {
+		return
+	}
+	filtered = !(tv.Value.ExactString() == "true")
+	return
+}
+
+const benchWhere = `id > 3 and username = "userName-27"`
+
+func benchRows(b *testing.B) (*Table, []*BPItem) {
+	db := NewDB()
+	newUserDB(b, db, 100)
+	table := db.GetTable("user")
+	var rows []*BPItem
+	for item := range table.GetClusterIndex().GetAllItems() {
+		rows = append(rows, item)
+	}
+	return table, rows
+}
+
+func BenchmarkWhereLegacy(b *testing.B) {
+	table, rows := benchRows(b)
+	where := []string{"id", ">", "3", "and", "username", "=", `"userName-27"`}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyIsRowFiltered(table, where, rows[i%len(rows)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWhereCompiled(b *testing.B) {
+	table, rows := benchRows(b)
+	where, err := ParseExpr(benchWhere)
+	if err != nil {
+		b.Fatal(err)
+	}
+	filter, err := compileFilter(where, table.tableResolver)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := filter(rows[i%len(rows)].Val.([]interface{})); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
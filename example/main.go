@@ -25,9 +25,12 @@ func example1() {
 		log.Fatalln(err)
 	}
 
+	insert, err := db.Prepare(`INSERT INTO user (id, username, email) VALUES (?, ?, ?)`)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	for i := 1; i != 30; i++ {
-		sql := fmt.Sprintf(`INSERT INTO user (id, username, email) VALUES (%d, "userName-%d", "User-%d@gmail.com")`, i, i, i)
-		if err = db.Exec(sql); err != nil {
+		if err = insert.Exec(i, fmt.Sprintf("userName-%d", i), fmt.Sprintf("User-%d@gmail.com", i)); err != nil {
 			log.Fatalln(err)
 		}
 	}
@@ -39,11 +42,22 @@ func example1() {
 	res := tree.Get(27)
 	fmt.Println(res)
 
-	result, err := db.Query(`SELECT email, id, username FROM user WHERE id > 3 LIMIT 10`)
+	rows, err := db.Query(`SELECT id, username, email FROM user WHERE id > 3 LIMIT 10`)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	fmt.Println(result)
+	fmt.Println(rows.Columns())
+	for rows.Next() {
+		var id int
+		var username, email string
+		if err := rows.Scan(&id, &username, &email); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(id, username, email)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalln(err)
+	}
 
 	err = db.Exec(`UPDATE user SET username = "newName222", email = "NewEmail111" WHERE username = "userName-27";`)
 	if err != nil {
@@ -63,11 +77,11 @@ func example1() {
 		log.Fatalln(err)
 	}
 
-	result, err = db.Query(`SELECT email, id, username FROM user WHERE id > 26`)
+	rows, err = db.Query(`SELECT email, id, username FROM user WHERE id > 26`)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	fmt.Println(result)
+	showRows(rows)
 
 	fmt.Println("------")
 
@@ -84,17 +98,18 @@ func example2() {
 		Name:       "user",
 		PrimaryKey: "id",
 		Columns:    []string{"id", "sex", "age", "username", "email", "phone"},
-		Constraint: map[string]func(data string) error{
+		Constraint: map[string]func(v interface{}) error{
 			"id": sqlite.Compose(sqlite.IsInteger, sqlite.NotEmpty),
-			"sex": func(data string) error {
-				return sqlite.OptionLimit[string](sqlite.TrimQuotes(data), []string{"male", "female"})
+			"sex": func(v interface{}) error {
+				sex, _ := v.(string)
+				return sqlite.OptionLimit[string](sex, []string{"male", "female"})
 			},
 			"age":      sqlite.IsSignedInteger,
-			"username": func(data string) error { return sqlite.VarcharTooLong(data, 16) },
+			"username": func(v interface{}) error { return sqlite.VarcharTooLong(v, 16) },
 			"email":    sqlite.IsString,
 			"phone":    sqlite.IsString,
 		},
-		Formatter: map[string]func(data string) interface{}{
+		Formatter: map[string]func(v interface{}) interface{}{
 			"id":       sqlite.IntegerFormatter,
 			"sex":      sqlite.StringFormatter,
 			"age":      sqlite.IntegerFormatter,
@@ -125,11 +140,22 @@ func example2() {
 	res := tree.Get(27)
 	fmt.Println(res)
 
-	result, err := db.Query(`SELECT email, id, username FROM user WHERE id > 20`)
+	rows, err := db.Query(`SELECT email, id, username FROM user WHERE id > 20`)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	fmt.Println(result)
+	showRows(rows)
+}
+
+func showRows(rows *sqlite.Rows) {
+	defer rows.Close()
+	fmt.Println(rows.Columns())
+	for rows.Next() {
+		fmt.Println(rows.Values())
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalln(err)
+	}
 }
 
 func showTree(tree *sqlite.BPTree) {
@@ -0,0 +1,509 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a node of a SQL expression, eg. the WHERE clause
+type Expr interface {
+	String() string
+}
+
+type ColumnRef struct {
+	Table  string // optional qualifier, eg. user in user.id
+	Name   string
+	Quoted *Literal // a bare "name" is this string if there is no such column
+}
+
+// Literal is a constant, Val is int, float64, string, []byte, bool or nil for NULL
+type Literal struct {
+	Val interface{}
+}
+
+type UnaryExpr struct {
+	Op   string // NOT or -
+	Expr Expr
+}
+
+type BinaryExpr struct {
+	Op          string // AND OR = != < <= > >= + - * / %
+	Left, Right Expr
+}
+
+// IsNull is x IS NULL, or x IS NOT NULL if Not is true
+type IsNull struct {
+	Expr Expr
+	Not  bool
+}
+
+// Param is a placeholder of a prepared statement, ? or $n. Index counts from 0,
+// the ? are numbered in the order they appear in the statement
+type Param struct {
+	Index int
+}
+
+// FuncCall is a function call, eg. COUNT(*) or SUM(age)
+type FuncCall struct {
+	Name string // upper case
+	Args []Expr
+	Star bool // COUNT(*)
+}
+
+// IntervalExpr is INTERVAL n unit, it is added to or subtracted from a time
+type IntervalExpr struct {
+	Expr Expr
+	Unit string // YEAR, MONTH, WEEK, DAY, HOUR, MINUTE or SECOND
+}
+
+func (e *ColumnRef) String() string {
+	if e.Quoted != nil {
+		return strconv.Quote(e.Quoted.Val.(string))
+	}
+	if e.Table != "" {
+		return e.Table + "." + e.Name
+	}
+	return e.Name
+}
+
+func (e *Literal) String() string {
+	switch v := e.Val.(type) {
+	case nil:
+		return NULL
+	case string:
+		return strconv.Quote(v)
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(v)) + "'"
+	case time.Time:
+		return strconv.Quote(formatTime(v))
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (e *Param) String() string {
+	return "$" + strconv.Itoa(e.Index+1)
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == "-" {
+		return "-" + e.Expr.String()
+	}
+	return e.Op + " " + e.Expr.String()
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op + " " + e.Right.String() + ")"
+}
+
+func (e *IsNull) String() string {
+	if e.Not {
+		return "(" + e.Expr.String() + " IS NOT NULL)"
+	}
+	return "(" + e.Expr.String() + " IS NULL)"
+}
+
+func (e *IntervalExpr) String() string {
+	return "INTERVAL " + e.Expr.String() + " " + e.Unit
+}
+
+func (e *FuncCall) String() string {
+	if currentTimes[e.Name] {
+		return e.Name
+	}
+	if e.Star {
+		return e.Name + "(*)"
+	}
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// walkExpr calls fn for e and all its sub expressions
+func walkExpr(e Expr, fn func(e Expr)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	switch e := e.(type) {
+	case *UnaryExpr:
+		walkExpr(e.Expr, fn)
+	case *IsNull:
+		walkExpr(e.Expr, fn)
+	case *IntervalExpr:
+		walkExpr(e.Expr, fn)
+	case *BinaryExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *FuncCall:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	}
+}
+
+// rewriteExpr returns a copy of e where every sub expression for which fn
+// returns a non nil expression is replaced by it
+func rewriteExpr(e Expr, fn func(e Expr) (Expr, error)) (Expr, error) {
+	if replaced, err := fn(e); replaced != nil || err != nil {
+		return replaced, err
+	}
+	var err error
+	switch e := e.(type) {
+	case *UnaryExpr:
+		u := *e
+		u.Expr, err = rewriteExpr(e.Expr, fn)
+		return &u, err
+	case *IsNull:
+		n := *e
+		n.Expr, err = rewriteExpr(e.Expr, fn)
+		return &n, err
+	case *IntervalExpr:
+		i := *e
+		i.Expr, err = rewriteExpr(e.Expr, fn)
+		return &i, err
+	case *BinaryExpr:
+		b := *e
+		if b.Left, err = rewriteExpr(e.Left, fn); err != nil {
+			return nil, err
+		}
+		b.Right, err = rewriteExpr(e.Right, fn)
+		return &b, err
+	case *FuncCall:
+		f := *e
+		f.Args = make([]Expr, len(e.Args))
+		for i, arg := range e.Args {
+			if f.Args[i], err = rewriteExpr(arg, fn); err != nil {
+				return nil, err
+			}
+		}
+		return &f, nil
+	}
+	return e, nil
+}
+
+// conjuncts splits e into the terms joined by AND
+func conjuncts(e Expr) []Expr {
+	if b, ok := e.(*BinaryExpr); ok && b.Op == "AND" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	if e == nil {
+		return nil
+	}
+	return []Expr{e}
+}
+
+var comparisonOps = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// exprParser is a recursive descent parser over the tokens of an expression
+type exprParser struct {
+	tokens []Token
+	pos    int
+	params *int // largest parameter number of the statement so far
+}
+
+/*
+ParseExpr parses an expression, from the lowest precedence:
+
+	OR
+	AND
+	NOT
+	= != <> < <= > >=, IS [NOT] NULL
+	+ -
+	* / %
+	unary -
+
+A bare "name" is a column if the table has it and a string otherwise, it is
+resolved against the tables before the statement runs, see resolveQuoted.
+A column quoted with ` is always a column.
+*/
+func ParseExpr(sql string) (Expr, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseExpr(tokens[:len(tokens)-1], new(int))
+	if err, ok := err.(*ParseError); ok {
+		err.SQL = sql
+	}
+	return e, err
+}
+
+// parseExpr parses an expression of a statement, params numbers the parameters of the statement
+func parseExpr(tokens []Token, params *int) (Expr, error) {
+	p := &exprParser{tokens: tokens, params: params}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, p.errorf(p.peek(), "unexpected %s after expression", p.peek())
+	}
+	return e, nil
+}
+
+// peek returns the next token, a TokenEOF after the last token at the end
+func (p *exprParser) peek() Token {
+	return tokenAt(p.tokens, p.pos)
+}
+
+func (p *exprParser) next() Token {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *exprParser) peekKeyword(keyword string) bool {
+	return p.peek().Keyword() == keyword
+}
+
+// expected returns a ParseError at tok, the parser sets its SQL
+func (p *exprParser) expected(tok Token, expected ...string) error {
+	return &ParseError{Position: tok.Pos, Found: tok, Expected: expected}
+}
+
+func (p *exprParser) errorf(tok Token, format string, a ...interface{}) error {
+	return &ParseError{Position: tok.Pos, Found: tok, Msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peekKeyword("OR") {
+		p.next()
+		var right Expr
+		if right, err = p.parseAnd(); err == nil {
+			left = &BinaryExpr{Op: "OR", Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peekKeyword("AND") {
+		p.next()
+		var right Expr
+		if right, err = p.parseNot(); err == nil {
+			left = &BinaryExpr{Op: "AND", Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (Expr, error) {
+	if p.peekKeyword("NOT") {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Expr: e}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.peekKeyword("IS") {
+		p.next()
+		e := &IsNull{Expr: left}
+		if p.peekKeyword("NOT") {
+			p.next()
+			e.Not = true
+		}
+		if tok := p.next(); tok.Keyword() != NULL {
+			if e.Not {
+				return nil, p.expected(tok, NULL)
+			}
+			return nil, p.expected(tok, "NOT", NULL)
+		}
+		return e, nil
+	}
+	op := p.peek().Text
+	if p.peek().Kind != TokenOperator {
+		return left, nil
+	}
+	switch op {
+	case "==":
+		op = "="
+	case "<>":
+		op = "!="
+	}
+	if !comparisonOps[op] {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{Op: op, Left: left, Right: right}, nil
+}
+
+func (p *exprParser) parseAdditive() (Expr, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil && (p.peek().Text == "+" || p.peek().Text == "-") {
+		op := p.next().Text
+		var right Expr
+		if right, err = p.parseMultiplicative(); err == nil {
+			left = &BinaryExpr{Op: op, Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseMultiplicative() (Expr, error) {
+	left, err := p.parseUnary()
+	for err == nil && (p.peek().Text == "*" || p.peek().Text == "/" || p.peek().Text == "%") {
+		op := p.next().Text
+		var right Expr
+		if right, err = p.parseUnary(); err == nil {
+			left = &BinaryExpr{Op: op, Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	if p.peek().Text == "-" {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// fold negative numbers into literals
+		if lit, ok := e.(*Literal); ok {
+			switch v := lit.Val.(type) {
+			case int:
+				return &Literal{Val: -v}, nil
+			case float64:
+				return &Literal{Val: -v}, nil
+			}
+		}
+		return &UnaryExpr{Op: "-", Expr: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch keyword := tok.Keyword(); {
+	case tok.Kind == TokenEOF:
+		return nil, p.expected(tok, "expression")
+	case tok.Text == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.Text != ")" {
+			return nil, p.expected(tok, ")")
+		}
+		return e, nil
+	case tok.Kind == TokenParam && tok.Text == "?":
+		*p.params++
+		return &Param{Index: *p.params - 1}, nil
+	case tok.Kind == TokenParam:
+		n, err := strconv.Atoi(tok.Text[1:])
+		if err != nil || n < 1 {
+			return nil, p.errorf(tok, "bad parameter %s", tok.Text)
+		}
+		if n > *p.params { // a later ? takes the next number, like in SQLite
+			*p.params = n
+		}
+		return &Param{Index: n - 1}, nil
+	case keyword == NULL:
+		return &Literal{Val: nil}, nil
+	case keyword == "TRUE" || keyword == "FALSE":
+		return &Literal{Val: keyword == "TRUE"}, nil
+	case currentTimes[keyword]:
+		return &FuncCall{Name: keyword}, nil
+	case keyword == "INTERVAL":
+		return p.parseInterval()
+	case tok.Kind == TokenQuotedIdent && tok.Text[0] == '"' && p.peek().Text != ".":
+		// a column if the table has it, a string otherwise, see resolveQuoted
+		return &ColumnRef{Name: strings.ToLower(tok.Value), Quoted: &Literal{Val: tok.Value}}, nil
+	case tok.Kind == TokenString:
+		return &Literal{Val: tok.Value}, nil
+	case tok.Kind == TokenBlob:
+		return &Literal{Val: []byte(tok.Value)}, nil
+	case tok.Kind == TokenNumber:
+		if v, err := strconv.Atoi(tok.Text); err == nil {
+			return &Literal{Val: v}, nil
+		}
+		if v, err := strconv.ParseFloat(tok.Text, 64); err == nil {
+			return &Literal{Val: v}, nil
+		}
+		return nil, p.errorf(tok, "bad number %s", tok.Text)
+	case tok.isName() && p.peek().Text == "(":
+		return p.parseCall(strings.ToUpper(tok.Value))
+	case tok.isName():
+		if p.peek().Text == "." {
+			p.next()
+			name := p.next()
+			if !name.isName() && name.Kind != TokenKeyword || name.Text[0] == '"' {
+				return nil, p.expected(name, "column")
+			}
+			return &ColumnRef{Table: strings.ToLower(tok.Value), Name: strings.ToLower(name.Value)}, nil
+		}
+		return &ColumnRef{Name: strings.ToLower(tok.Value)}, nil
+	default:
+		return nil, p.expected(tok, "expression")
+	}
+}
+
+// parseInterval parses "n unit" after INTERVAL, the unit may be plural, eg. INTERVAL 2 DAYS
+func (p *exprParser) parseInterval() (Expr, error) {
+	e, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	unit := strings.TrimSuffix(strings.ToUpper(tok.Value), "S")
+	for _, u := range intervalUnits {
+		if tok.isName() && unit == u {
+			return &IntervalExpr{Expr: e, Unit: unit}, nil
+		}
+	}
+	return nil, p.expected(tok, intervalUnits...)
+}
+
+// parseCall parses the arguments of a function call, the name has been scanned
+func (p *exprParser) parseCall(name string) (Expr, error) {
+	p.next() // (
+	call := &FuncCall{Name: name}
+	switch p.peek().Text {
+	case "*":
+		p.next()
+		call.Star = true
+	case ")":
+	default:
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if p.peek().Text != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if tok := p.next(); tok.Text != ")" {
+		return nil, p.expected(tok, ",", ")")
+	}
+	return call, nil
+}
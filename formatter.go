@@ -1,7 +1,7 @@
 package sqlite
 
 import (
-	"strconv"
+	"math"
 	"strings"
 )
 
@@ -11,26 +11,60 @@ func TrimQuotes(data string) string {
 	return data
 }
 
-func StringFormatter(data string) interface{} {
-	return TrimQuotes(data)
+// A formatter converts a value which passed the constraint of its column to
+// the type stored in the table. NULL stays nil.
+
+func StringFormatter(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// IntegerFormatter stores a float without fraction as int
+func IntegerFormatter(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return int(f)
+	}
+	return v
+}
+
+func BoolFormatter(v interface{}) interface{} {
+	return v
 }
 
-func IntegerFormatter(data string) interface{} {
-	d, err := strconv.Atoi(data)
-	if err != nil {
-		panic(err)
+// RealFormatter stores an int as float64
+func RealFormatter(v interface{}) interface{} {
+	if i, ok := v.(int); ok {
+		return float64(i)
 	}
-	return d
-}
-
-func BoolFormatter(data string) interface{} {
-	d := strings.ToUpper(data)
-	switch d {
-	case "TRUE":
-		return true
-	case "FALSE":
-		return false
-	default:
-		panic("data is not bool")
+	return v
+}
+
+// BlobFormatter stores a string as []byte, a bound []byte argument is passed as string
+func BlobFormatter(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	return v
+}
+
+// TimeFormatter returns the formatter of a DATE, TIME or TIMESTAMP column, it stores a string as time.Time
+func TimeFormatter(kind string) func(v interface{}) interface{} {
+	return func(v interface{}) interface{} {
+		if t, err := toTime(kind, v); err == nil {
+			return t
+		}
+		return v
+	}
+}
+
+// DecimalFormatter returns the formatter of a DECIMAL column, it rounds the value to scale
+func DecimalFormatter(scale int) func(v interface{}) interface{} {
+	return func(v interface{}) interface{} {
+		if d, ok := decimalValue(v); ok {
+			return d.rescale(scale)
+		}
+		return v
 	}
 }
@@ -0,0 +1,279 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	IndexExistError = fmt.Errorf("index already exists")
+	IndexNameError  = fmt.Errorf("invalid index name")
+)
+
+/*
+A secondary index is a B+tree in Table.Indies keyed by indexKey(value).
+The key of a string is its first 8 bytes, so several values can share a
+key: the item value is the list of (value, primary key) entries, where the
+primary key is the key of the row in the clustered index.
+*/
+type indexEntry struct {
+	Val interface{}
+	PK  int64
+}
+
+// primaryIndex is the unique index of a primary key which does not cluster the rows, see
+// Table.clusterKey. The value of a composite key is the list of its values.
+const primaryIndex = "+"
+
+// indexKey maps a column value to a B+tree key preserving the order of values
+func indexKey(v interface{}) int64 {
+	switch val := v.(type) {
+	case int:
+		return int64(val)
+	case bool:
+		if val {
+			return 1
+		}
+		return 0
+	case float64:
+		// flip all bits of a negative float and the sign bit of a positive one, so that
+		// the unsigned order of the bits is the order of the floats
+		bits := math.Float64bits(val)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return int64(bits ^ 1<<63)
+	case time.Time:
+		return val.Unix()
+	case Decimal:
+		return indexKey(val.Float64())
+	case string:
+		return indexKey([]byte(val))
+	case []interface{}:
+		return indexKey(val[0]) // a composite key is ordered by its first value first
+	case []byte:
+		var prefix [8]byte
+		copy(prefix[:], val)
+		// flip the sign bit, so that unsigned byte order becomes int64 order
+		return int64(binary.BigEndian.Uint64(prefix[:]) ^ 1<<63)
+	default:
+		panic(fmt.Sprintf("can not index value of type %T", v))
+	}
+}
+
+// columnIdx returns the index of a column, column names are case insensitive
+func (t *Table) columnIdx(col string) int {
+	for idx, c := range t.Columns {
+		if strings.EqualFold(c, col) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// CreateIndex builds the index from the rows of the table
+func (t *Table) CreateIndex(ast *CreateIndexAST) error {
+	if ast.Name == "-" || ast.Name == primaryIndex || ast.Name == "" {
+		return IndexNameError
+	}
+	if _, ok := t.Indies[ast.Name]; ok {
+		return IndexExistError
+	}
+	col := t.columnIdx(ast.Column)
+	if col == -1 {
+		return HasNotColumnError
+	}
+
+	tree := NewBPTree(17, nil)
+	for item := range t.GetClusterIndex().GetAllItems() {
+		val := item.Val.([]interface{})[col]
+		if ast.Unique && indexContains(tree, val, item.Key) {
+			return DuplicateKeyError
+		}
+		addIndexEntry(tree, val, item.Key)
+	}
+
+	t.Indies[ast.Name] = tree
+	if t.IndexSchema == nil {
+		t.IndexSchema = make(map[string]*CreateIndexAST)
+	}
+	t.IndexSchema[ast.Name] = ast
+	return nil
+}
+
+// primaryValue returns the value of the row in the primary index
+func (t *Table) primaryValue(row []interface{}) interface{} {
+	primaryKey := t.primaryKey()
+	if len(primaryKey) == 1 {
+		return row[t.columnIdx(primaryKey[0])]
+	}
+	val := make([]interface{}, len(primaryKey))
+	for i, col := range primaryKey {
+		val[i] = row[t.columnIdx(col)]
+	}
+	return val
+}
+
+// buildPrimaryIndex builds the primary index from the rows, if the table needs one
+func (t *Table) buildPrimaryIndex() error {
+	delete(t.Indies, primaryIndex)
+	if len(t.primaryKey()) == 0 || t.clusterKey() != "" {
+		return nil
+	}
+	tree := NewBPTree(17, nil)
+	for item := range t.GetClusterIndex().GetAllItems() {
+		val := t.primaryValue(item.Val.([]interface{}))
+		if indexContains(tree, val, item.Key) {
+			return DuplicateKeyError
+		}
+		addIndexEntry(tree, val, item.Key)
+	}
+	t.Indies[primaryIndex] = tree
+	return nil
+}
+
+// searchIndexes returns the indexes which can find rows by the value of a column, see indexColumn
+func (t *Table) searchIndexes() []string {
+	names := t.indexNames()
+	if _, ok := t.Indies[primaryIndex]; ok {
+		names = append(names, primaryIndex)
+	}
+	return names
+}
+
+// indexColumn returns the column an index is searched by, the first one of a composite primary key
+func (t *Table) indexColumn(name string) string {
+	if name == primaryIndex {
+		return strings.ToLower(t.primaryKey()[0])
+	}
+	return t.IndexSchema[name].Column
+}
+
+func (t *Table) DropIndex(name string) {
+	delete(t.Indies, name)
+	delete(t.IndexSchema, name)
+}
+
+// indexNames returns the names of secondary indexes in a stable order
+func (t *Table) indexNames() []string {
+	names := make([]string, 0, len(t.IndexSchema))
+	for name := range t.IndexSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkUnique reports whether the row of key would break a unique index or the primary key
+func (t *Table) checkUnique(key int64, row []interface{}) error {
+	if tree := t.Indies[primaryIndex]; tree != nil && indexContains(tree, t.primaryValue(row), key) {
+		return DuplicateKeyError
+	}
+	for name, ast := range t.IndexSchema {
+		if !ast.Unique {
+			continue
+		}
+		if indexContains(t.Indies[name], row[t.columnIdx(ast.Column)], key) {
+			return DuplicateKeyError
+		}
+	}
+	return nil
+}
+
+func (t *Table) addIndexEntries(key int64, row []interface{}) {
+	if tree := t.Indies[primaryIndex]; tree != nil {
+		addIndexEntry(tree, t.primaryValue(row), key)
+	}
+	for name, ast := range t.IndexSchema {
+		addIndexEntry(t.Indies[name], row[t.columnIdx(ast.Column)], key)
+	}
+}
+
+func (t *Table) removeIndexEntries(key int64, row []interface{}) {
+	if tree := t.Indies[primaryIndex]; tree != nil {
+		removeIndexEntry(tree, t.primaryValue(row), key)
+	}
+	for name, ast := range t.IndexSchema {
+		removeIndexEntry(t.Indies[name], row[t.columnIdx(ast.Column)], key)
+	}
+}
+
+// NULL is not indexed: it equals nothing, so it is neither found by a lookup nor a duplicate
+
+// indexContains reports whether another row than pk has the value
+func indexContains(tree *BPTree, val interface{}, pk int64) bool {
+	if val == nil {
+		return false
+	}
+	entries, _ := tree.Get(indexKey(val)).([]indexEntry)
+	for _, entry := range entries {
+		if entry.PK != pk && compareEqual(entry.Val, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func addIndexEntry(tree *BPTree, val interface{}, pk int64) {
+	if val == nil {
+		return
+	}
+	key := indexKey(val)
+	entries, _ := tree.Get(key).([]indexEntry)
+	newEntries := make([]indexEntry, 0, len(entries)+1)
+	newEntries = append(newEntries, entries...)
+	tree.Set(key, append(newEntries, indexEntry{Val: val, PK: pk}))
+}
+
+func removeIndexEntry(tree *BPTree, val interface{}, pk int64) {
+	if val == nil {
+		return
+	}
+	key := indexKey(val)
+	entries, _ := tree.Get(key).([]indexEntry)
+	newEntries := make([]indexEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.PK != pk {
+			newEntries = append(newEntries, entry)
+		}
+	}
+	if len(newEntries) == 0 {
+		tree.Remove(key)
+	} else {
+		tree.Set(key, newEntries)
+	}
+}
+
+// indexLookup returns the primary keys of rows whose indexed value is in r, in ascending order
+func (t *Table) indexLookup(name string, r *keyRange) []int64 {
+	tree := t.Indies[name]
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if r.Lo != nil {
+		lo = indexKey(r.Lo)
+	}
+	if r.Hi != nil {
+		hi = indexKey(r.Hi)
+	}
+
+	var pks []int64
+	tree.rangeItems(lo, hi, func(item *BPItem) bool {
+		for _, entry := range item.Val.([]indexEntry) {
+			val := entry.Val
+			if key, ok := val.([]interface{}); ok {
+				val = key[0]
+			}
+			if r.contains(val) {
+				pks = append(pks, entry.PK)
+			}
+		}
+		return true
+	})
+	sort.Slice(pks, func(i, j int) bool { return pks[i] < pks[j] })
+	return pks
+}
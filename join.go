@@ -0,0 +1,342 @@
+package sqlite
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// joinTable is a table of FROM or JOIN, its columns start at offset in the joined row
+type joinTable struct {
+	table  *Table
+	name   string // the alias, or the table name
+	offset int
+}
+
+// joinTables resolves the columns of joined rows, a column without table must be in exactly one table
+type joinTables []*joinTable
+
+func (tables joinTables) resolve(col *ColumnRef) (int, error) {
+	found := -1
+	for _, jt := range tables {
+		if col.Table != "" && col.Table != jt.name {
+			continue
+		}
+		for idx, c := range jt.table.Columns {
+			if strings.ToLower(c) != col.Name {
+				continue
+			}
+			if found != -1 {
+				return -1, fmt.Errorf("ambiguous column %s", col)
+			}
+			found = jt.offset + idx
+		}
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("%s: %s", HasNotColumnError, col)
+	}
+	return found, nil
+}
+
+func (tables joinTables) width() int {
+	last := tables[len(tables)-1]
+	return last.offset + len(last.table.Columns)
+}
+
+func (p *Plan) joinTables(ast *SelectAST) (joinTables, error) {
+	if len(p.joined) != len(ast.Joins) {
+		return nil, fmt.Errorf("the plan has %d joined tables, the query joins %d", len(p.joined), len(ast.Joins))
+	}
+	name := ast.Alias
+	if name == "" {
+		name = ast.Table
+	}
+	tables := joinTables{{table: p.table, name: name}}
+	for i, join := range ast.Joins {
+		name := join.Alias
+		if name == "" {
+			name = join.Table
+		}
+		for _, jt := range tables {
+			if jt.name == name {
+				return nil, fmt.Errorf("table %s is joined twice, use an alias", name)
+			}
+		}
+		tables = append(tables, &joinTable{table: p.joined[i], name: name, offset: tables.width()})
+	}
+	return tables, nil
+}
+
+// matchFunc returns the rows of the right table which may match a row of the left tables
+type matchFunc func(left []interface{}) ([][]interface{}, error)
+
+/*
+join joins the rows of the left tables with the right table. The rows which
+may match a left row are found by the first "left = right" term of ON:
+
+	right.PrimaryKey = expr    index nested loop join, a point lookup per left row
+	expr = expr                hash join, the right table is hashed once
+	otherwise                  nested loop join over all rows of the right table
+
+The whole ON is checked on every pair.
+*/
+func (p *Plan) join(left Operator, leftTables joinTables, right *joinTable, clause *JoinClause) (Operator, error) {
+	tables := append(joinTables{}, leftTables...)
+	tables = append(tables, right)
+	on, err := compileFilter(clause.On, tables.resolve)
+	if err != nil {
+		return nil, err
+	}
+	match, err := p.matcher(tables, right, clause.On)
+	if err != nil {
+		return nil, err
+	}
+	return &joinOp{left: left, match: match, on: on, outer: clause.Left, width: len(right.table.Columns)}, nil
+}
+
+// joinOp returns the left row joined with every matching right row,
+// a LEFT JOIN pads a left row without any match with NULL
+type joinOp struct {
+	left  Operator
+	match matchFunc
+	on    rowFilter
+	outer bool
+	width int // number of columns of the right table
+
+	leftRow *BPItem
+	rows    [][]interface{} // the right rows which may match leftRow
+	matched bool
+}
+
+func (j *joinOp) Open() error { return j.left.Open() }
+
+func (j *joinOp) Next() (*BPItem, error) {
+	for {
+		if j.leftRow == nil {
+			row, err := j.left.Next()
+			if row == nil || err != nil {
+				return nil, err
+			}
+			if j.rows, err = j.match(row.Val.([]interface{})); err != nil {
+				return nil, err
+			}
+			j.leftRow, j.matched = row, false
+		}
+
+		leftVal := j.leftRow.Val.([]interface{})
+		for len(j.rows) > 0 {
+			row := append(append(make([]interface{}, 0, len(leftVal)+j.width), leftVal...), j.rows[0]...)
+			j.rows = j.rows[1:]
+			pass, err := j.on(row)
+			if err != nil {
+				return nil, err
+			}
+			if pass {
+				j.matched = true
+				return &BPItem{Key: j.leftRow.Key, Val: row}, nil
+			}
+		}
+
+		leftRow := j.leftRow
+		j.leftRow = nil
+		if j.outer && !j.matched {
+			row := append(append(make([]interface{}, 0, len(leftVal)+j.width), leftVal...), make([]interface{}, j.width)...)
+			return &BPItem{Key: leftRow.Key, Val: row}, nil
+		}
+	}
+}
+
+func (j *joinOp) Close() error { return j.left.Close() }
+
+// matcher picks the join algorithm for ON, see join
+func (p *Plan) matcher(tables joinTables, right *joinTable, on Expr) (matchFunc, error) {
+	// rightTable resolves the columns of rows of the right table alone
+	rightTable := joinTables{{table: right.table, name: right.name}}
+
+	var hashLeft, hashRight Expr
+	for _, term := range conjuncts(on) {
+		b, ok := term.(*BinaryExpr)
+		if !ok || b.Op != "=" {
+			continue
+		}
+		l, r := tables.side(b.Left, right), tables.side(b.Right, right)
+		if l == sideRight && r == sideLeft {
+			l, r = r, l
+			b = &BinaryExpr{Op: b.Op, Left: b.Right, Right: b.Left}
+		}
+		if l != sideLeft || r != sideRight {
+			continue
+		}
+
+		if ref, ok := b.Right.(*ColumnRef); ok {
+			clusterKey := right.table.clusterKey()
+			if idx, err := tables.resolve(ref); err == nil && clusterKey != "" && strings.EqualFold(right.table.Columns[idx-right.offset], clusterKey) {
+				key, err := compileExpr(b.Left, tables.resolve)
+				if err != nil {
+					return nil, err
+				}
+				return indexLookupMatch(right.table, key), nil
+			}
+		}
+		if hashLeft == nil {
+			hashLeft, hashRight = b.Left, b.Right
+		}
+	}
+
+	if hashLeft != nil {
+		leftKey, err := compileExpr(hashLeft, tables.resolve)
+		if err != nil {
+			return nil, err
+		}
+		rightKey, err := compileExpr(hashRight, rightTable.resolve)
+		if err != nil {
+			return nil, err
+		}
+		return hashMatch(right.table, leftKey, rightKey), nil
+	}
+	return nestedLoopMatch(right.table), nil
+}
+
+const (
+	sideNone  = iota // a constant
+	sideLeft         // only columns of the left tables
+	sideRight        // only columns of the right table
+	sideBoth
+)
+
+// side tells which tables the columns of e belong to
+func (tables joinTables) side(e Expr, right *joinTable) int {
+	side := sideNone
+	walkExpr(e, func(e Expr) {
+		ref, ok := e.(*ColumnRef)
+		if !ok {
+			return
+		}
+		s := sideLeft
+		if idx, err := tables.resolve(ref); err != nil {
+			s = sideBoth
+		} else if idx >= right.offset {
+			s = sideRight
+		}
+		if side != sideNone && side != s {
+			s = sideBoth
+		}
+		side = s
+	})
+	if side == sideNone {
+		return sideLeft // a constant is evaluated per left row as well
+	}
+	return side
+}
+
+// indexLookupMatch finds the right row by its primary key
+func indexLookupMatch(table *Table, key evalFunc) matchFunc {
+	return func(left []interface{}) ([][]interface{}, error) {
+		v, err := key(left)
+		if err != nil {
+			return nil, err
+		}
+		var pk int64
+		switch v := v.(type) {
+		case nil:
+			return nil, nil // NULL never equals anything
+		case int:
+			pk = int64(v)
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, nil
+			}
+			pk = int64(v)
+		case Decimal:
+			if v = v.normalize(); v.scale != 0 || !v.int().IsInt64() {
+				return nil, nil
+			}
+			pk = v.int().Int64()
+		default:
+			// the key can not be compared with an INTEGER, ON fails on any row as WHERE does
+			c := table.GetClusterIndex().NewCursor(false)
+			defer c.Close()
+			if c.First() {
+				return [][]interface{}{c.Value().([]interface{})}, nil
+			}
+			return nil, nil
+		}
+		if val := table.GetClusterIndex().Get(pk); val != nil {
+			return [][]interface{}{val.([]interface{})}, nil
+		}
+		return nil, nil
+	}
+}
+
+/*
+hashMatch hashes the right table by rightKey when the first left row is probed.
+A key only finds the rows whose key has the same type, the rows of any other
+type are returned too, so that ON compares them and fails as WHERE does if the
+types can not be compared, eg. INTEGER = VARCHAR.
+*/
+func hashMatch(table *Table, leftKey, rightKey evalFunc) matchFunc {
+	var hash map[string][][]interface{}
+	var types map[byte][][]interface{} // the rows by the type tag of their key
+	return func(left []interface{}) ([][]interface{}, error) {
+		if hash == nil {
+			hash, types = make(map[string][][]interface{}), make(map[byte][][]interface{})
+			for item := range table.GetClusterIndex().GetAllItems() {
+				row := item.Val.([]interface{})
+				v, err := rightKey(row)
+				if err != nil {
+					return nil, err
+				}
+				if v == nil {
+					continue // NULL never equals anything
+				}
+				key, err := hashKey(v)
+				if err != nil {
+					return nil, err
+				}
+				hash[key] = append(hash[key], row)
+				types[key[0]] = append(types[key[0]], row)
+			}
+		}
+
+		v, err := leftKey(left)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		key, err := hashKey(v)
+		if err != nil {
+			return nil, err
+		}
+		rows := hash[key]
+		for tag, others := range types {
+			if tag != key[0] {
+				rows = append(rows[:len(rows):len(rows)], others...)
+			}
+		}
+		return rows, nil
+	}
+}
+
+// hashKey encodes a value so that equal values have equal keys, a number is
+// hashed as a decimal, so that 1, 1.0 and a DECIMAL 1.00 have the same key
+func hashKey(v interface{}) (string, error) {
+	if d, ok := toDecimal(v); ok {
+		v = d.normalize()
+	}
+	key, err := appendValue(nil, v)
+	return string(key), err
+}
+
+// nestedLoopMatch returns all rows of the right table, they are read once
+func nestedLoopMatch(table *Table) matchFunc {
+	var rows [][]interface{}
+	var loaded bool
+	return func(left []interface{}) ([][]interface{}, error) {
+		if !loaded {
+			for item := range table.GetClusterIndex().GetAllItems() {
+				rows = append(rows, item.Val.([]interface{}))
+			}
+			loaded = true
+		}
+		return rows, nil
+	}
+}
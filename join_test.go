@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newOrderDB(t *testing.T) *DB {
+	db := NewDB()
+	newUserDB(t, db, 5)
+	for _, sql := range []string{
+		`CREATE TABLE order (
+			id       INTEGER   NOT NULL,
+			user_id  INTEGER   NOT NULL,
+			amount   INTEGER   NOT NULL,
+			PRIMARY KEY (id)
+		);`,
+		`INSERT INTO order (id, user_id, amount) VALUES (1, 1, 100), (2, 1, 50), (3, 2, 70), (4, 99, 10)`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestJoin(t *testing.T) {
+	db := newOrderDB(t)
+
+	type rows = [][]interface{}
+	for sql, want := range map[string]rows{
+		// hash join, order.user_id is not the primary key of order
+		`SELECT user.username, order.amount FROM user JOIN order ON user.id = order.user_id ORDER BY order.id`: {
+			{"userName-1", 100}, {"userName-1", 50}, {"userName-2", 70},
+		},
+		`SELECT u.id, o.id FROM user u LEFT OUTER JOIN order o ON u.id = o.user_id ORDER BY u.id, o.id`: {
+			{1, 1}, {1, 2}, {2, 3}, {3, nil}, {4, nil}, {5, nil},
+		},
+		// index nested loop join, u.id is the primary key of user
+		`SELECT o.id, username FROM order AS o INNER JOIN user AS u ON o.user_id = u.id`: {
+			{1, "userName-1"}, {2, "userName-1"}, {3, "userName-2"},
+		},
+		`SELECT o.id, username FROM order o LEFT JOIN user u ON u.id = o.user_id WHERE o.id > 2`: {
+			{3, "userName-2"}, {4, nil},
+		},
+		// nested loop join
+		`SELECT COUNT(*) FROM user u JOIN order o ON u.id < o.user_id`: {{6}},
+		`SELECT u.id, COUNT(o.id) FROM user u LEFT JOIN order o ON u.id = o.user_id AND o.amount > 60 GROUP BY u.id`: {
+			{1, 1}, {2, 1}, {3, 0}, {4, 0}, {5, 0},
+		},
+		`SELECT u.username, SUM(amount) FROM user u JOIN order o ON u.id = o.user_id GROUP BY u.username ORDER BY SUM(amount) DESC`: {
+			{"userName-1", 150}, {"userName-2", 70},
+		},
+		`SELECT a.id, b.id FROM user u JOIN order a ON a.user_id = u.id JOIN order b ON b.user_id = u.id AND b.id != a.id`: {
+			{1, 2}, {2, 1},
+		},
+		`SELECT * FROM user u JOIN order o ON u.id = o.user_id LIMIT 1`: {
+			{"User-1@gmail.com", "userName-1", 1, 1, 1, 100},
+		},
+		`SELECT u.id FROM user u WHERE u.id < 3`: {{1}, {2}},
+	} {
+		if got := queryValues(t, db, sql); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", sql, got, want)
+		}
+	}
+
+	for _, sql := range []string{
+		`SELECT id FROM user JOIN order ON user.id = order.user_id`, // ambiguous
+		`SELECT user.id FROM user JOIN nosuch ON user.id = nosuch.id`,
+		`SELECT user.id FROM user JOIN order ON user.id = order.nosuch`,
+		`SELECT user.id FROM user JOIN user ON user.id = user.id`,
+		`SELECT user.id FROM user JOIN order`,
+		`SELECT user.id FROM user JOIN order ON`,
+		`SELECT user.id FROM user u WHERE user.id = 1`,
+	} {
+		if err := queryError(db, sql); err == nil {
+			t.Errorf("%s: expect error, got nil", sql)
+		}
+	}
+
+	// ON fails as WHERE does if the types can not be compared
+	for on, where := range map[string]string{
+		`SELECT u.id FROM user u JOIN order o ON u.email = o.user_id`:   `SELECT u.id FROM user u JOIN order o ON 1 = 1 WHERE u.email = o.user_id`,
+		`SELECT u.id FROM user u JOIN order o ON o.user_id = u.email`:   `SELECT u.id FROM user u JOIN order o ON 1 = 1 WHERE o.user_id = u.email`,
+		`SELECT u.id FROM user u LEFT JOIN user v ON v.id = u.username`: `SELECT u.id FROM user u JOIN user v ON 1 = 1 WHERE v.id = u.username`,
+	} {
+		want := queryError(db, where)
+		if err := queryError(db, on); err == nil || want == nil || err.Error() != want.Error() {
+			t.Errorf("%s: got error %v, want %v", on, err, want)
+		}
+	}
+}
+
+func TestJoinAlgorithm(t *testing.T) {
+	db := newOrderDB(t)
+	p := NewPlan(db.GetTable("order"), db.GetTable("user"))
+	for on, want := range map[string]string{
+		`o.user_id = u.id`:                      "index",
+		`u.id = o.user_id + 0`:                  "index",
+		`u.username = "x" AND o.user_id = u.id`: "index",
+		`u.id % 2 = o.user_id`:                  "hash",
+		`o.id = u.id + 1`:                       "hash",
+		`o.user_id < u.id`:                      "loop",
+		`o.user_id = u.id OR o.id = u.id`:       "loop",
+		`o.id + u.id = 2`:                       "loop",
+	} {
+		ast, err := (&Parser{}).ParseSelect(`SELECT * FROM order o JOIN user u ON ` + on)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables, err := p.joinTables(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := p.matcher(tables, tables[1], ast.Joins[0].On)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// probe with order 1, a lookup by primary key finds exactly one user
+		rows, err := match([]interface{}{1, 1, 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		switch len(rows) {
+		case 1:
+			got = "index"
+		case 5:
+			got = "loop"
+		default:
+			got = "hash"
+		}
+		if got != want {
+			t.Errorf("ON %s: got %s join, want %s join", on, got, want)
+		}
+	}
+}
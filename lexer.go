@@ -0,0 +1,390 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenKind is the kind of a Token
+type TokenKind int
+
+const (
+	TokenEOF         TokenKind = iota
+	TokenIdent                 // name
+	TokenKeyword               // SELECT, FROM, ... in any case
+	TokenQuotedIdent           // `name` or "name"
+	TokenString                // 'text'
+	TokenNumber                // 1, 1.5, .5, 1e3
+	TokenBlob                  // X'0A1B'
+	TokenParam                 // ? or $n
+	TokenOperator              // ( ) , ; . + - * / % = == != <> < <= > >= ||
+)
+
+var tokenKinds = [...]string{"EOF", "identifier", "keyword", "quoted identifier", "string", "number", "blob", "parameter", "operator"}
+
+func (k TokenKind) String() string {
+	return tokenKinds[k]
+}
+
+// Position is a position in a statement, Line and Column start at 1, Column counts runes
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+type Token struct {
+	Kind  TokenKind
+	Text  string // as written in the statement
+	Value string // the name of an identifier, the content of a string or blob, otherwise Text
+	Pos   Position
+}
+
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return "EOF"
+	}
+	return strconv.Quote(t.Text)
+}
+
+// Keyword returns the upper case keyword, or "" if the token is not a keyword
+func (t Token) Keyword() string {
+	if t.Kind != TokenKeyword {
+		return ""
+	}
+	return strings.ToUpper(t.Text)
+}
+
+// isName reports whether the token can be the name of a table, column or alias.
+// The keywords which are not reserved are names too, eg. order or key
+func (t Token) isName() bool {
+	switch t.Kind {
+	case TokenIdent, TokenQuotedIdent:
+		return true
+	case TokenKeyword:
+		return !reserved[t.Keyword()]
+	}
+	return false
+}
+
+var keywords = map[string]bool{}
+
+// reserved are the keywords which are never names, quote them to use them as names
+var reserved = map[string]bool{}
+
+func init() {
+	for _, keyword := range []string{
+		SELECT, INSERT, UPDATE, DELETE, CREATE, TABLE, INDEX, UNIQUE, ON, BEGIN, COMMIT, ROLLBACK,
+		FROM, AS, JOIN, INNER, LEFT, OUTER, WHERE, GROUP, HAVING, ORDER, BY, ASC, DESC, LIMIT, INTO, VALUES, Set,
+		NULL, DEFAULT, PRIMARY, KEY, DROP, TRUNCATE, ALTER, IF, EXISTS, ADD, COLUMN, RENAME, TO,
+		"AND", "OR", "NOT", "IS", "TRUE", "FALSE", "INTERVAL", "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP",
+	} {
+		keywords[keyword] = true
+	}
+	for _, keyword := range []string{
+		SELECT, INSERT, UPDATE, DELETE, CREATE, TABLE, INDEX, ON, FROM, AS, JOIN, INNER, LEFT, WHERE,
+		HAVING, LIMIT, INTO, VALUES, Set, NULL, DEFAULT, "AND", "OR", "NOT", "IS", "TRUE", "FALSE",
+		"INTERVAL", "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP",
+	} {
+		reserved[keyword] = true
+	}
+}
+
+var operators = []string{"<=", ">=", "<>", "!=", "==", "||", "(", ")", ",", ";", ".", "+", "-", "*", "/", "%", "=", "<", ">"}
+
+// Tokenize splits a statement into tokens which end with a TokenEOF. Strings are
+// quoted with ' and identifiers with ` or ", a quote is escaped by doubling it:
+//
+//	SELECT `user name` FROM "my table" WHERE name = 'it''s' -- comment
+//
+// Comments are -- up to the end of the line and /* up to the next */.
+// On error it returns the tokens before the error.
+func Tokenize(sql string) ([]Token, error) {
+	l := &lexer{src: sql, pos: Position{Line: 1, Column: 1}}
+	var tokens []Token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, tok)
+		if tok.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+type lexer struct {
+	src string
+	pos Position
+}
+
+// peek returns the rune at n bytes after the position, or -1
+func (l *lexer) peek(n int) rune {
+	if l.pos.Offset+n >= len(l.src) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos.Offset+n:])
+	return r
+}
+
+func (l *lexer) advance() {
+	r, size := utf8.DecodeRuneInString(l.src[l.pos.Offset:])
+	l.pos.Offset += size
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+}
+
+// errorf returns a ParseError from pos up to the current position
+func (l *lexer) errorf(pos Position, format string, a ...interface{}) error {
+	found := Token{Kind: TokenOperator, Text: l.src[pos.Offset:l.pos.Offset], Pos: pos}
+	return &ParseError{SQL: l.src, Position: pos, Found: found, Msg: fmt.Sprintf(format, a...)}
+}
+
+// skip skips spaces and comments
+func (l *lexer) skip() error {
+	for {
+		switch r := l.peek(0); {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '-' && l.peek(1) == '-':
+			for l.peek(0) != -1 && l.peek(0) != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peek(1) == '*':
+			start := l.pos
+			l.advance()
+			l.advance()
+			for !(l.peek(0) == '*' && l.peek(1) == '/') {
+				if l.peek(0) == -1 {
+					return l.errorf(start, "unterminated comment")
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *lexer) next() (Token, error) {
+	if err := l.skip(); err != nil {
+		return Token{}, err
+	}
+	start := l.pos
+	token := func(kind TokenKind, value string) Token {
+		text := l.src[start.Offset:l.pos.Offset]
+		if kind != TokenString && kind != TokenQuotedIdent && kind != TokenBlob {
+			value = text
+		}
+		return Token{Kind: kind, Text: text, Value: value, Pos: start}
+	}
+
+	switch r := l.peek(0); {
+	case r == -1:
+		return Token{Kind: TokenEOF, Pos: start}, nil
+	case (r == 'x' || r == 'X') && l.peek(1) == '\'':
+		l.advance()
+		s, err := l.quoted()
+		if err != nil {
+			return Token{}, err
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return Token{}, l.errorf(start, "bad blob %s", l.src[start.Offset:l.pos.Offset])
+		}
+		return token(TokenBlob, string(b)), nil
+	case isIdentStart(r):
+		for isIdentPart(l.peek(0)) {
+			l.advance()
+		}
+		tok := token(TokenIdent, "")
+		if keywords[strings.ToUpper(tok.Text)] {
+			tok.Kind = TokenKeyword
+		}
+		return tok, nil
+	case r >= '0' && r <= '9' || r == '.' && l.peek(1) >= '0' && l.peek(1) <= '9':
+		if err := l.number(); err != nil {
+			return Token{}, err
+		}
+		return token(TokenNumber, ""), nil
+	case r == '\'':
+		s, err := l.quoted()
+		if err != nil {
+			return Token{}, err
+		}
+		return token(TokenString, s), nil
+	case r == '"' || r == '`':
+		s, err := l.quoted()
+		if err != nil {
+			return Token{}, err
+		}
+		return token(TokenQuotedIdent, s), nil
+	case r == '?':
+		l.advance()
+		return token(TokenParam, ""), nil
+	case r == '$':
+		l.advance()
+		if r := l.peek(0); r < '0' || r > '9' {
+			return Token{}, l.errorf(start, "expect parameter number after $")
+		}
+		for r := l.peek(0); r >= '0' && r <= '9'; r = l.peek(0) {
+			l.advance()
+		}
+		return token(TokenParam, ""), nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos.Offset:], op) {
+			for range op {
+				l.advance()
+			}
+			return token(TokenOperator, ""), nil
+		}
+	}
+	return Token{}, l.errorf(start, "unexpected character %q", l.peek(0))
+}
+
+// quoted scans a string quoted by the current rune, it returns the content
+func (l *lexer) quoted() (string, error) {
+	start := l.pos
+	quote := l.peek(0)
+	l.advance()
+	var b strings.Builder
+	for {
+		switch r := l.peek(0); r {
+		case -1:
+			return "", l.errorf(start, "unterminated %c", quote)
+		case quote:
+			l.advance()
+			if l.peek(0) != quote {
+				return b.String(), nil
+			}
+			b.WriteRune(quote) // doubled quote
+			l.advance()
+		default:
+			b.WriteRune(r)
+			l.advance()
+		}
+	}
+}
+
+// number scans digits [. digits] [e [+-] digits]
+func (l *lexer) number() error {
+	start := l.pos
+	digits := func() {
+		for r := l.peek(0); r >= '0' && r <= '9'; r = l.peek(0) {
+			l.advance()
+		}
+	}
+	digits()
+	if l.peek(0) == '.' {
+		l.advance()
+		digits()
+	}
+	if r := l.peek(0); r == 'e' || r == 'E' {
+		l.advance()
+		if r := l.peek(0); r == '+' || r == '-' {
+			l.advance()
+		}
+		if r := l.peek(0); r < '0' || r > '9' {
+			return l.errorf(start, "bad number %s", l.src[start.Offset:l.pos.Offset])
+		}
+		digits()
+	}
+	if isIdentPart(l.peek(0)) {
+		l.advance()
+		return l.errorf(start, "bad number %s", l.src[start.Offset:l.pos.Offset])
+	}
+	return nil
+}
+
+// unquote returns the content of a quoted string, identifier or blob, other text as it is
+func unquote(text string) string {
+	tokens, err := Tokenize(text)
+	if err == nil && len(tokens) == 2 && (tokens[0].Kind == TokenString || tokens[0].Kind == TokenQuotedIdent || tokens[0].Kind == TokenBlob) {
+		return tokens[0].Value
+	}
+	return text
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f && unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || r >= '0' && r <= '9' || r == '$' || r > 0x7f && unicode.IsDigit(r)
+}
+
+// tokenAt returns tokens[i], or a TokenEOF after the last token
+func tokenAt(tokens []Token, i int) Token {
+	if i < len(tokens) {
+		return tokens[i]
+	}
+	end := Token{Kind: TokenEOF, Pos: Position{Line: 1, Column: 1}}
+	if n := len(tokens); n > 0 {
+		last := tokens[n-1]
+		end.Pos = last.Pos
+		end.Pos.Offset += len(last.Text)
+		end.Pos.Column += utf8.RuneCountInString(last.Text)
+	}
+	return end
+}
+
+// Lexer walks the tokens of a statement for the parser
+type Lexer struct {
+	tokens []Token
+	pos    int // index of the current token
+}
+
+// Init tokenizes sql, Scan returns the first token
+func (l *Lexer) Init(sql string) error {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return err
+	}
+	l.tokens, l.pos = tokens, -1
+	return nil
+}
+
+// Scan moves to the next token and returns its kind, it stays at the EOF
+func (l *Lexer) Scan() TokenKind {
+	if l.pos < len(l.tokens)-1 {
+		l.pos++
+	}
+	return l.Token().Kind
+}
+
+// Token returns the current token
+func (l *Lexer) Token() Token {
+	if l.pos < 0 {
+		return Token{Pos: Position{Line: 1, Column: 1}}
+	}
+	return l.tokens[l.pos]
+}
+
+func (l *Lexer) TokenText() string {
+	return l.Token().Text
+}
+
+// Peek returns the token after the current one
+func (l *Lexer) Peek() Token {
+	if l.pos+1 < len(l.tokens) {
+		return l.tokens[l.pos+1]
+	}
+	return l.tokens[len(l.tokens)-1]
+}
@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize("SELECT `user name`, \"t\".id FROM t -- comment\nWHERE /* a\nb */ name >= 'it''s' AND x<>1.5e3 OR b = x'0aFF' AND c = ? + $2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type token struct {
+		Kind  TokenKind
+		Value string
+		Pos   string
+	}
+	var got []token
+	for _, tok := range tokens {
+		got = append(got, token{tok.Kind, tok.Value, tok.Pos.String()})
+	}
+	want := []token{
+		{TokenKeyword, "SELECT", "1:1"},
+		{TokenQuotedIdent, "user name", "1:8"},
+		{TokenOperator, ",", "1:19"},
+		{TokenQuotedIdent, "t", "1:21"},
+		{TokenOperator, ".", "1:24"},
+		{TokenIdent, "id", "1:25"},
+		{TokenKeyword, "FROM", "1:28"},
+		{TokenIdent, "t", "1:33"},
+		{TokenKeyword, "WHERE", "2:1"},
+		{TokenIdent, "name", "3:6"},
+		{TokenOperator, ">=", "3:11"},
+		{TokenString, "it's", "3:14"},
+		{TokenKeyword, "AND", "3:22"},
+		{TokenIdent, "x", "3:26"},
+		{TokenOperator, "<>", "3:27"},
+		{TokenNumber, "1.5e3", "3:29"},
+		{TokenKeyword, "OR", "3:35"},
+		{TokenIdent, "b", "3:38"},
+		{TokenOperator, "=", "3:40"},
+		{TokenBlob, "\x0a\xff", "3:42"},
+		{TokenKeyword, "AND", "3:50"},
+		{TokenIdent, "c", "3:54"},
+		{TokenOperator, "=", "3:56"},
+		{TokenParam, "?", "3:58"},
+		{TokenOperator, "+", "3:60"},
+		{TokenParam, "$2", "3:62"},
+		{TokenOperator, ";", "3:64"},
+		{TokenEOF, "", "3:65"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	for sql, want := range map[string]string{
+		`SELECT 'abc`:          `1:8: unterminated '`,
+		"SELECT 1\n  /* x":     `2:3: unterminated comment`,
+		`SELECT 12ab`:          `1:8: bad number 12a`,
+		`SELECT x'0g'`:         `1:8: bad blob x'0g'`,
+		`SELECT $a`:            `1:8: expect parameter number after $`,
+		"SELECT id\nFROM t #":  `2:8: unexpected character '#'`,
+		"SELECT \"a\nb\" FROM": ``,
+	} {
+		_, err := Tokenize(sql)
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: %s", sql, err)
+			}
+		} else if err == nil || !strings.HasPrefix(err.Error(), want+"\n") {
+			t.Errorf("%s: got error %v, want %s", sql, err, want)
+		}
+	}
+}
+
+func TestLexerStatements(t *testing.T) {
+	db := NewDB()
+	err := db.Exec(`
+	-- a table with quoted names
+	CREATE TABLE "my table" (
+		` + "`user name`" + ` VARCHAR(16) NOT NULL DEFAULT 'it''s',
+		id                    INTEGER     NOT NULL,
+		PRIMARY KEY (id)
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		`INSERT INTO "my table" (id) VALUES (1)`,
+		"INSERT INTO `my table` (id, `user name`) VALUES (2, 'a \"b\" -- c'), (3, \"d\")",
+		"UPDATE `my table` SET \"user name\" = 'e''f' WHERE id>=3",
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+
+	rows, err := db.Query("SELECT `user name` FROM `my table` WHERE id <> 0 /* all */ ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"it's", `a "b" -- c`, "e'f"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+
+	// the errors tell the position and the token
+	for sql, want := range map[string]string{
+		"SELECT id FROM t\nWHERE id = = 1": `2:12: expected expression, found "="`,
+		"SELECT id FROM t WHERE (id = 1":   `1:31: expected ")", found EOF`,
+		"INSERT INTO t (id) VALUES (1) x":  `1:31: expected "(" or ",", found "x"`,
+		"SELECT 'abc":                      `1:8: unterminated '`,
+	} {
+		_, err := db.Prepare(sql)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %s", sql, err, want)
+		}
+	}
+}
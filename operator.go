@@ -0,0 +1,282 @@
+package sqlite
+
+import (
+	"math"
+)
+
+/*
+Operator is a node of the Volcano model. A query is a tree of operators,
+every Next pulls one row from the children, so rows stream from the table
+to the caller without being materialized, except by Sort and Aggregate
+which need all their input first.
+
+	op.Open()
+	defer op.Close()
+	for row, err := op.Next(); row != nil; row, err = op.Next() { ... }
+
+Next returns a nil row when there are no more rows. The Key of a row is
+the primary key for the rows of a single table.
+*/
+type Operator interface {
+	Open() error
+	Next() (*BPItem, error)
+	Close() error
+}
+
+// drain returns all rows of the operator
+func drain(op Operator) (rows []*BPItem, err error) {
+	defer func() {
+		if closeErr := op.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err := op.Open(); err != nil {
+		return nil, err
+	}
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+/*
+scanOp reads the rows of a table which may pass where, in primary key order,
+descending if reverse. WHERE on the primary key becomes a point lookup or a
+range scan of the leaf chain, WHERE on an indexed column becomes an index
+lookup, otherwise all rows are scanned. The cursor takes the read lock on
+every row, so the table may be modified while the scan is open.
+*/
+type scanOp struct {
+	plan    *Plan
+	where   Expr
+	reverse bool
+
+	byKey  bool
+	keys   []int64 // the primary keys to look up if byKey
+	cursor *Cursor // else the cursor over lo <= key <= hi
+	lo, hi int64
+}
+
+func (s *scanOp) Open() error {
+	p := s.plan
+	if p.table.GetClusterIndex() == nil {
+		return TableError
+	}
+	ranges := p.whereRanges(s.where)
+
+	var index string
+	for _, name := range p.table.searchIndexes() {
+		r := ranges[p.table.indexColumn(name)]
+		if r == nil {
+			continue
+		}
+		if index == "" || (r.isPoint() && !ranges[p.table.indexColumn(index)].isPoint()) {
+			index = name
+		}
+	}
+
+	var pkRange *keyRange
+	if clusterKey := p.table.clusterKey(); clusterKey != "" {
+		pkRange = ranges[clusterKey]
+	}
+	switch {
+	case pkRange != nil && (pkRange.isPoint() || index == "" || !ranges[p.table.indexColumn(index)].isPoint()):
+		lo, hi, ok := pkRange.keys()
+		switch {
+		case !ok:
+			s.byKey = true // empty range
+		case lo == hi:
+			s.byKey, s.keys = true, []int64{lo}
+		default:
+			s.lo, s.hi = lo, hi
+		}
+	case index != "":
+		s.byKey = true
+		s.keys = p.table.indexLookup(index, ranges[p.table.indexColumn(index)])
+		if s.reverse {
+			for i, j := 0, len(s.keys)-1; i < j; i, j = i+1, j-1 {
+				s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+			}
+		}
+	default:
+		s.lo, s.hi = math.MinInt64, math.MaxInt64
+	}
+	return nil
+}
+
+func (s *scanOp) Next() (*BPItem, error) {
+	tree := s.plan.table.GetClusterIndex()
+	if s.byKey {
+		for len(s.keys) > 0 {
+			key := s.keys[0]
+			s.keys = s.keys[1:]
+			if val := tree.Get(key); val != nil {
+				return &BPItem{Key: key, Val: val}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	var ok bool
+	switch {
+	case s.cursor != nil:
+		ok = s.cursor.Next()
+	case s.reverse:
+		s.cursor = tree.NewCursor(true)
+		ok = s.cursor.SeekTo(s.hi)
+	default:
+		s.cursor = tree.NewCursor(false)
+		ok = s.cursor.SeekTo(s.lo)
+	}
+	if !ok || s.cursor.Key() < s.lo || s.cursor.Key() > s.hi {
+		return nil, nil
+	}
+	return &BPItem{Key: s.cursor.Key(), Val: s.cursor.Value()}, nil
+}
+
+func (s *scanOp) Close() error {
+	if s.cursor != nil {
+		s.cursor.Close()
+	}
+	return nil
+}
+
+// filterOp passes the rows for which filter is TRUE
+type filterOp struct {
+	input  Operator
+	filter rowFilter
+}
+
+func (f *filterOp) Open() error { return f.input.Open() }
+
+func (f *filterOp) Next() (*BPItem, error) {
+	for {
+		row, err := f.input.Next()
+		if row == nil || err != nil {
+			return nil, err
+		}
+		pass, err := f.filter(row.Val.([]interface{}))
+		if err != nil {
+			return nil, err
+		}
+		if pass {
+			return row, nil
+		}
+	}
+}
+
+func (f *filterOp) Close() error { return f.input.Close() }
+
+// projectOp evaluates the projections of every row, a nil projection is all the columns
+type projectOp struct {
+	input  Operator
+	fields []evalFunc
+}
+
+func (p *projectOp) Open() error { return p.input.Open() }
+
+func (p *projectOp) Next() (*BPItem, error) {
+	row, err := p.input.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	if len(p.fields) == 1 && p.fields[0] == nil {
+		return row, nil
+	}
+
+	val := make([]interface{}, 0, len(p.fields))
+	for _, field := range p.fields {
+		if field == nil {
+			val = append(val, row.Val.([]interface{})...)
+			continue
+		}
+		v, err := field(row.Val.([]interface{}))
+		if err != nil {
+			return nil, err
+		}
+		val = append(val, v)
+	}
+	return &BPItem{Key: row.Key, Val: val}, nil
+}
+
+func (p *projectOp) Close() error { return p.input.Close() }
+
+// limitOp stops after limit rows, the input is not read any further
+type limitOp struct {
+	input Operator
+	limit int64
+	count int64
+}
+
+func (l *limitOp) Open() error { return l.input.Open() }
+
+func (l *limitOp) Next() (*BPItem, error) {
+	if l.count >= l.limit {
+		return nil, nil
+	}
+	l.count++
+	return l.input.Next()
+}
+
+func (l *limitOp) Close() error { return l.input.Close() }
+
+// sortOp reads all its input on Open and returns it sorted by ORDER BY
+type sortOp struct {
+	input   Operator
+	orderBy []*OrderByTerm
+	resolve columnResolver
+	rows    []*BPItem
+}
+
+func (s *sortOp) Open() error {
+	if err := s.input.Open(); err != nil {
+		return err
+	}
+	for {
+		row, err := s.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		s.rows = append(s.rows, row)
+	}
+	return sortRows(s.rows, s.orderBy, s.resolve)
+}
+
+func (s *sortOp) Next() (*BPItem, error) {
+	if len(s.rows) == 0 {
+		return nil, nil
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+func (s *sortOp) Close() error { return s.input.Close() }
+
+// bufferOp returns the rows which were read in advance, then the error which stopped the reading
+type bufferOp struct {
+	rows []*BPItem
+	err  error
+}
+
+func (b *bufferOp) Open() error { return nil }
+
+func (b *bufferOp) Next() (*BPItem, error) {
+	if len(b.rows) == 0 {
+		return nil, b.err
+	}
+	row := b.rows[0]
+	b.rows = b.rows[1:]
+	return row, nil
+}
+
+func (b *bufferOp) Close() error { return nil }
@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+// countOp counts the rows pulled through it
+type countOp struct {
+	Operator
+	count int
+}
+
+func (c *countOp) Next() (*BPItem, error) {
+	row, err := c.Operator.Next()
+	if row != nil {
+		c.count++
+	}
+	return row, err
+}
+
+func TestOperatorStreams(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 100)
+	table := db.GetTable("user")
+	plan := NewPlan(table)
+
+	// LIMIT stops pulling rows from the scan
+	scan := &countOp{Operator: &scanOp{plan: plan}}
+	rows, err := drain(&limitOp{input: &filterOp{input: scan, filter: func(row []interface{}) (bool, error) {
+		return row[2].(int)%10 == 0, nil
+	}}, limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || scan.count != 20 {
+		t.Errorf("expect 2 rows from 20 scanned rows, got %d rows from %d", len(rows), scan.count)
+	}
+
+	// rows are read one at a time, a change of the table is seen by the open scan
+	ast, err := (&Parser{}).ParseSelect(`SELECT id FROM user WHERE id > 97`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := plan.Build(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Open(); err != nil {
+		t.Fatal(err)
+	}
+	var ids []interface{}
+	for {
+		row, err := op.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		ids = append(ids, row.Val.([]interface{})[0])
+		if len(ids) == 1 {
+			if err := db.Exec(`DELETE FROM user WHERE id = 99`); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Exec(`INSERT INTO user (id, username) VALUES (1000, "new")`); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := op.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []interface{}{98, 100, 1000}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+}
+
+func TestOperatorSortAndAggregate(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 10)
+	plan := NewPlan(db.GetTable("user"))
+
+	for sql, want := range map[string][][]interface{}{
+		`SELECT id FROM user ORDER BY username DESC LIMIT 2`:                        {{9}, {8}},
+		`SELECT id % 2, MAX(id) FROM user GROUP BY id % 2 ORDER BY MAX(id) LIMIT 1`: {{1, 9}},
+	} {
+		ast, err := (&Parser{}).ParseSelect(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		op, err := plan.Build(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := drain(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]interface{}
+		for _, row := range rows {
+			got = append(got, row.Val.([]interface{}))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", sql, got, want)
+		}
+	}
+}
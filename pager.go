@@ -0,0 +1,411 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+The database file is a sequence of fixed size pages:
+
+	page 0         header: magic, page size, page count, catalog location, generation
+	page 1..n      B+tree nodes, one node per record, a record may span pages
+	page n+1..     catalog: table schemas, the node directory and index schemas
+
+Every record starts at a page boundary. The node directory maps BPNode.ID
+to the page (file offset = page * PageSize) holding the node, so children
+and the leaf chain are stored as node IDs and linked again on load.
+Only the clustered index is stored, secondary indexes are rebuilt on load.
+*/
+
+const PageSize = 4096
+
+var fileMagic = []byte("SQLTOY\x00\x01")
+
+var (
+	NotDatabaseFileError = fmt.Errorf("file is not a database")
+	CorruptFileError     = fmt.Errorf("database file is corrupt")
+)
+
+type fileHeader struct {
+	PageCount    uint32
+	CatalogPage  uint32
+	CatalogBytes uint32
+	Generation   uint64 // bumped by every checkpoint, see wal.go
+}
+
+// nodeLocation is an entry of the node directory
+type nodeLocation struct {
+	ID    int
+	Page  uint32
+	Bytes uint32
+}
+
+type pageWriter struct {
+	buf []byte
+}
+
+func newPageWriter() *pageWriter {
+	return &pageWriter{buf: make([]byte, PageSize)} // page 0 is reserved for header
+}
+
+// write appends a record at the next page boundary and returns its first page.
+func (w *pageWriter) write(record []byte) uint32 {
+	page := uint32(len(w.buf) / PageSize)
+	w.buf = append(w.buf, record...)
+	if rem := len(w.buf) % PageSize; rem != 0 {
+		w.buf = append(w.buf, make([]byte, PageSize-rem)...)
+	}
+	return page
+}
+
+func (w *pageWriter) finish(catalog []byte, generation uint64) []byte {
+	catalogPage := w.write(catalog)
+	header := w.buf[:0:PageSize]
+	header = append(header, fileMagic...)
+	header = binary.BigEndian.AppendUint32(header, PageSize)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(w.buf)/PageSize))
+	header = binary.BigEndian.AppendUint32(header, catalogPage)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(catalog)))
+	header = binary.BigEndian.AppendUint64(header, generation)
+	return w.buf
+}
+
+func readHeader(data []byte) (*fileHeader, error) {
+	if len(data) < PageSize || !bytes.Equal(data[:len(fileMagic)], fileMagic) {
+		return nil, NotDatabaseFileError
+	}
+	b := data[len(fileMagic):]
+	if binary.BigEndian.Uint32(b) != PageSize {
+		return nil, fmt.Errorf("unsupported page size %d", binary.BigEndian.Uint32(b))
+	}
+	h := &fileHeader{
+		PageCount:    binary.BigEndian.Uint32(b[4:]),
+		CatalogPage:  binary.BigEndian.Uint32(b[8:]),
+		CatalogBytes: binary.BigEndian.Uint32(b[12:]),
+		Generation:   binary.BigEndian.Uint64(b[16:]),
+	}
+	if int(h.PageCount)*PageSize != len(data) {
+		return nil, CorruptFileError
+	}
+	return h, nil
+}
+
+func readRecord(data []byte, page, size uint32) ([]byte, error) {
+	start := int(page) * PageSize
+	if page == 0 || start+int(size) > len(data) {
+		return nil, CorruptFileError
+	}
+	return data[start : start+int(size)], nil
+}
+
+func encodeNode(node *BPNode) ([]byte, error) {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(node.ID))
+	buf = binary.AppendVarint(buf, node.MaxKey)
+	if node.IsLeaf() {
+		buf = append(buf, 1)
+		buf = binary.AppendUvarint(buf, uint64(len(node.Items)))
+		for _, item := range node.Items {
+			var err error
+			buf = binary.AppendVarint(buf, item.Key)
+			if buf, err = appendValue(buf, item.Val); err != nil {
+				return nil, err
+			}
+		}
+		var next int
+		if node.Next != nil {
+			next = node.Next.ID
+		}
+		buf = binary.AppendUvarint(buf, uint64(next))
+	} else {
+		buf = append(buf, 0)
+		buf = binary.AppendUvarint(buf, uint64(len(node.Children)))
+		for _, child := range node.Children {
+			buf = binary.AppendUvarint(buf, uint64(child.ID))
+		}
+	}
+	return buf, nil
+}
+
+// decodeNode returns the node and the IDs of its children or its next leaf
+func decodeNode(record []byte) (node *BPNode, links []int, err error) {
+	d := &decoder{buf: record}
+	node = &BPNode{ID: int(d.uvarint()), MaxKey: d.varint()}
+	if isLeaf := d.byte() == 1; isLeaf {
+		n := d.count()
+		node.Items = make([]*BPItem, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key := d.varint()
+			node.Items = append(node.Items, &BPItem{Key: key, Val: d.value()})
+		}
+		links = append(links, int(d.uvarint()))
+	} else {
+		n := d.uvarint()
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			links = append(links, int(d.uvarint()))
+		}
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return node, links, nil
+}
+
+func (t *BPTree) collectNodes(node *BPNode, nodes []*BPNode) []*BPNode {
+	nodes = append(nodes, node)
+	for _, child := range node.Children {
+		nodes = t.collectNodes(child, nodes)
+	}
+	return nodes
+}
+
+func appendSchema(buf []byte, ast *CreateTableAST) []byte {
+	buf = appendBytes(buf, []byte(ast.Table))
+	// the columns of a composite primary key are joined by NUL, a single one is stored as it is
+	buf = appendBytes(buf, []byte(strings.Join(ast.PrimaryKey, "\x00")))
+	buf = binary.AppendUvarint(buf, uint64(len(ast.Columns)))
+	for idx, col := range ast.Columns {
+		buf = appendBytes(buf, []byte(col))
+		buf = appendBytes(buf, []byte(ast.Type[idx]))
+		if ast.NotNull[idx] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = appendBytes(buf, []byte(ast.Default[idx]))
+	}
+	return buf
+}
+
+func (d *decoder) schema() *CreateTableAST {
+	ast := &CreateTableAST{Table: d.string()}
+	if primaryKey := d.string(); primaryKey != "" {
+		ast.PrimaryKey = strings.Split(primaryKey, "\x00")
+	}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		ast.Columns = append(ast.Columns, d.string())
+		ast.Type = append(ast.Type, d.string())
+		ast.NotNull = append(ast.NotNull, d.byte() == 1)
+		ast.Default = append(ast.Default, d.string())
+	}
+	return ast
+}
+
+// encodeFile serializes all tables of db into the page format
+func (db *DB) encodeFile() ([]byte, error) {
+	names := make([]string, 0, len(db.Tables))
+	for name := range db.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := newPageWriter()
+	catalog := binary.AppendUvarint(nil, uint64(len(names)))
+	for _, name := range names {
+		table := db.Tables[name]
+		if table.Schema == nil {
+			return nil, fmt.Errorf("table %s has no schema, can not be saved", name)
+		}
+		tree := table.GetClusterIndex()
+
+		tree.mutex.RLock()
+		var dir []nodeLocation
+		for _, node := range tree.collectNodes(tree.root, nil) {
+			record, err := encodeNode(node)
+			if err != nil {
+				tree.mutex.RUnlock()
+				return nil, fmt.Errorf("table %s: %s", name, err)
+			}
+			dir = append(dir, nodeLocation{ID: node.ID, Page: w.write(record), Bytes: uint32(len(record))})
+		}
+		rootID := tree.root.ID
+		tree.mutex.RUnlock()
+
+		catalog = appendSchema(catalog, table.Schema)
+		catalog = binary.AppendUvarint(catalog, uint64(tree.width))
+		catalog = binary.AppendUvarint(catalog, uint64(rootID))
+		catalog = binary.AppendUvarint(catalog, uint64(len(dir)))
+		for _, loc := range dir {
+			catalog = binary.AppendUvarint(catalog, uint64(loc.ID))
+			catalog = binary.AppendUvarint(catalog, uint64(loc.Page))
+			catalog = binary.AppendUvarint(catalog, uint64(loc.Bytes))
+		}
+
+		indexNames := table.indexNames()
+		catalog = binary.AppendUvarint(catalog, uint64(len(indexNames)))
+		for _, indexName := range indexNames {
+			ast := table.IndexSchema[indexName]
+			catalog = appendBytes(catalog, []byte(ast.Name))
+			catalog = appendBytes(catalog, []byte(ast.Column))
+			if ast.Unique {
+				catalog = append(catalog, 1)
+			} else {
+				catalog = append(catalog, 0)
+			}
+		}
+	}
+	return w.finish(catalog, db.generation), nil
+}
+
+// decodeFile loads the tables stored in data into db
+func (db *DB) decodeFile(data []byte) error {
+	header, err := readHeader(data)
+	if err != nil {
+		return err
+	}
+	catalog, err := readRecord(data, header.CatalogPage, header.CatalogBytes)
+	if err != nil {
+		return err
+	}
+	db.generation = header.Generation
+
+	d := &decoder{buf: catalog}
+	tableCnt := d.uvarint()
+	for i := uint64(0); i < tableCnt && d.err == nil; i++ {
+		schema := d.schema()
+		width := int(d.uvarint())
+		rootID := int(d.uvarint())
+		nodeCnt := d.count()
+		if d.err != nil || nodeCnt > uint64(header.PageCount) {
+			return CorruptFileError
+		}
+		dir := make([]nodeLocation, nodeCnt)
+		for idx := range dir {
+			dir[idx] = nodeLocation{ID: int(d.uvarint()), Page: uint32(d.uvarint()), Bytes: uint32(d.uvarint())}
+		}
+		if d.err != nil {
+			break
+		}
+
+		table, err := db.NewTable(schema)
+		if err != nil {
+			return fmt.Errorf("table %s: %s", schema.Table, err)
+		}
+		tree, err := loadTree(data, width, rootID, dir)
+		if err != nil {
+			return fmt.Errorf("table %s: %s", schema.Table, err)
+		}
+		table.Indies["-"] = tree
+		if err := table.buildPrimaryIndex(); err != nil {
+			return fmt.Errorf("table %s: %s", table.Name, err)
+		}
+
+		indexCnt := d.uvarint()
+		for idx := uint64(0); idx < indexCnt && d.err == nil; idx++ {
+			ast := &CreateIndexAST{Name: d.string(), Table: table.Name, Column: d.string(), Unique: d.byte() == 1}
+			if d.err != nil {
+				break
+			}
+			if err := table.CreateIndex(ast); err != nil {
+				return fmt.Errorf("table %s index %s: %s", table.Name, ast.Name, err)
+			}
+		}
+		db.AddTable(table)
+	}
+	if d.err != nil {
+		return CorruptFileError
+	}
+	return nil
+}
+
+func loadTree(data []byte, width, rootID int, dir []nodeLocation) (*BPTree, error) {
+	nodes := make(map[int]*BPNode, len(dir))
+	links := make(map[int][]int, len(dir))
+	for _, loc := range dir {
+		record, err := readRecord(data, loc.Page, loc.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		node, nodeLinks, err := decodeNode(record)
+		if err != nil || node.ID != loc.ID {
+			return nil, CorruptFileError
+		}
+		nodes[node.ID] = node
+		links[node.ID] = nodeLinks
+		reserveNodeID(node.ID)
+	}
+
+	for id, node := range nodes {
+		// decodeNode always allocates Items for leaves, even empty ones
+		if node.Items != nil {
+			if next := links[id][0]; next != 0 {
+				if node.Next = nodes[next]; node.Next == nil {
+					return nil, CorruptFileError
+				}
+			}
+			continue
+		}
+		for _, childID := range links[id] {
+			child := nodes[childID]
+			if child == nil {
+				return nil, CorruptFileError
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	root := nodes[rootID]
+	if root == nil {
+		return nil, CorruptFileError
+	}
+	return &BPTree{
+		root:      root,
+		width:     width,
+		halfWidth: (width + 1) / 2,
+		genNodeID: defaultNewID,
+	}, nil
+}
+
+// writeFile replaces path atomically with data
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (db *DB) load() error {
+	data, err := os.ReadFile(db.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.decodeFile(data)
+}
+
+func (db *DB) flush() error {
+	data, err := db.encodeFile()
+	if err != nil {
+		return err
+	}
+	return writeFile(db.path, data)
+}
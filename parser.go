@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"text/scanner"
 )
 
 type StatementType string
@@ -17,11 +16,38 @@ const (
 	UPDATE      = "UPDATE"
 	DELETE      = "DELETE"
 
-	CREATE = "CREATE"
-	TABLE  = "TABLE"
+	CREATE   = "CREATE"
+	TABLE    = "TABLE"
+	INDEX    = "INDEX"
+	UNIQUE   = "UNIQUE"
+	ON       = "ON"
+	DROP     = "DROP"
+	TRUNCATE = "TRUNCATE"
+	ALTER    = "ALTER"
+	IF       = "IF"
+	EXISTS   = "EXISTS"
+	ADD      = "ADD"
+	COLUMN   = "COLUMN"
+	RENAME   = "RENAME"
+	TO       = "TO"
+
+	BEGIN    = "BEGIN"
+	COMMIT   = "COMMIT"
+	ROLLBACK = "ROLLBACK"
 
 	FROM   = "FROM"
+	AS     = "AS"
+	JOIN   = "JOIN"
+	INNER  = "INNER"
+	LEFT   = "LEFT"
+	OUTER  = "OUTER"
 	WHERE  = "WHERE"
+	GROUP  = "GROUP"
+	HAVING = "HAVING"
+	ORDER  = "ORDER"
+	BY     = "BY"
+	ASC    = "ASC"
+	DESC   = "DESC"
 	LIMIT  = "LIMIT"
 	INTO   = "INTO"
 	VALUES = "VALUES"
@@ -39,15 +65,109 @@ const (
 )
 
 type Parser struct {
-	s scanner.Scanner
+	s      Lexer
+	sql    string
+	params int // largest parameter number of the statement
+}
+
+func (p *Parser) init(sql string) error {
+	p.sql, p.params = sql, 0
+	return p.s.Init(sql)
+}
+
+/*
+ParseError is a syntax error of a statement, Error shows the line of the
+error with a caret under the token:
+
+	1:13: expected table, found "VALUES"
+	INSERT INTO VALUES (1)
+	            ^
+*/
+type ParseError struct {
+	SQL string
+	Position
+	Found    Token    // the token at the error, TokenEOF at the end of the statement
+	Expected []string // what is allowed at the position, eg. INTO, table or )
+	Msg      string   // what is wrong, if Expected does not tell
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Msg
+	if len(e.Expected) > 0 {
+		if msg != "" {
+			msg += ", "
+		}
+		msg += fmt.Sprintf("expected %s, found %s", expectedList(e.Expected), e.Found)
+	}
+	msg = fmt.Sprintf("%s: %s", e.Position, msg)
+	if e.SQL == "" || e.Offset > len(e.SQL) {
+		return msg
+	}
+
+	start := strings.LastIndexByte(e.SQL[:e.Offset], '\n') + 1
+	end := len(e.SQL)
+	if i := strings.IndexByte(e.SQL[e.Offset:], '\n'); i >= 0 {
+		end = e.Offset + i
+	}
+	var caret strings.Builder
+	for _, r := range e.SQL[start:e.Offset] {
+		if r == '\t' {
+			caret.WriteRune(r)
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	return msg + "\n" + e.SQL[start:end] + "\n" + caret.String() + "^"
+}
+
+// expectedList joins a, b and c as "a, b or c", the punctuations are quoted
+func expectedList(expected []string) string {
+	var b strings.Builder
+	for i, e := range expected {
+		if i == len(expected)-1 && i > 0 {
+			b.WriteString(" or ")
+		} else if i > 0 {
+			b.WriteString(", ")
+		}
+		if isIdentStart([]rune(e)[0]) {
+			b.WriteString(e)
+		} else {
+			b.WriteString(strconv.Quote(e))
+		}
+	}
+	return b.String()
+}
+
+// expected returns a ParseError at the current token
+func (p *Parser) expected(expected ...string) error {
+	return p.errorAt(p.s.Token(), expected...)
+}
+
+func (p *Parser) errorAt(tok Token, expected ...string) error {
+	return &ParseError{SQL: p.sql, Position: tok.Pos, Found: tok, Expected: expected}
+}
+
+// errorf returns a ParseError with a message at the current token
+func (p *Parser) errorf(format string, a ...interface{}) error {
+	tok := p.s.Token()
+	return &ParseError{SQL: p.sql, Position: tok.Pos, Found: tok, Msg: fmt.Sprintf(format, a...)}
+}
+
+// parseExpr parses the tokens of an expression of the statement
+func (p *Parser) parseExpr(tokens []Token) (Expr, error) {
+	e, err := parseExpr(tokens, &p.params)
+	if err, ok := err.(*ParseError); ok {
+		err.SQL = p.sql
+	}
+	return e, err
 }
 
 func (p *Parser) GetSQLType(sql string) StatementType {
-	s := p.s
-	s.Init(strings.NewReader(sql))
-	s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	// the tokens before an error are enough, the parser reports the error
+	tokens, _ := Tokenize(sql)
+	s := Lexer{tokens: append(tokens, Token{Kind: TokenEOF}), pos: -1}
 
-	if tok := s.Scan(); tok != scanner.EOF {
+	if tok := s.Scan(); tok != TokenEOF {
 		txt := strings.ToUpper(s.TokenText())
 		switch txt {
 		case "SELECT":
@@ -59,7 +179,25 @@ func (p *Parser) GetSQLType(sql string) StatementType {
 		case "DELETE":
 			return DELETE
 		case "CREATE":
+			// CREATE [UNIQUE] INDEX
+			if tok := s.Scan(); tok != TokenEOF {
+				if next := strings.ToUpper(s.TokenText()); next == INDEX || next == UNIQUE {
+					return INDEX
+				}
+			}
 			return CREATE
+		case "DROP":
+			return DROP
+		case "TRUNCATE":
+			return TRUNCATE
+		case "ALTER":
+			return ALTER
+		case "BEGIN":
+			return BEGIN
+		case "COMMIT":
+			return COMMIT
+		case "ROLLBACK":
+			return ROLLBACK
 		default:
 			return UNSUPPORTED
 		}
@@ -68,10 +206,19 @@ func (p *Parser) GetSQLType(sql string) StatementType {
 	return UNSUPPORTED
 }
 
+// unsupported returns the error of a statement which GetSQLType does not know
+func (p *Parser) unsupported(sql string) error {
+	if err := p.init(sql); err != nil {
+		return err
+	}
+	p.s.Scan()
+	return p.expected(SELECT, INSERT, UPDATE, DELETE, CREATE, DROP, TRUNCATE, ALTER, BEGIN, COMMIT, ROLLBACK)
+}
+
 type InsertAST struct {
 	Table   string
 	Columns []string
-	Values  [][]string
+	Values  [][]Expr // constant expressions or parameters
 }
 
 /*
@@ -81,33 +228,34 @@ ParseInsert can parse a simple INSERT statement, eg.
 	INSERT INTO table_name(column1, column2, …) VALUES (value1, value2, …)
 */
 func (p *Parser) ParseInsert(insert string) (ast *InsertAST, err error) {
-	p.s.Init(strings.NewReader(insert))
-	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	if err = p.init(insert); err != nil {
+		return nil, err
+	}
 
 	if !p.scanAndCheck(&p.s, INSERT) {
-		return nil, fmt.Errorf("not INSERT statement")
+		return nil, p.expected(INSERT)
 	}
 	if !p.scanAndCheck(&p.s, INTO) {
-		return nil, fmt.Errorf("expect INTO after INSERT")
+		return nil, p.expected(INTO)
 	}
 
 	ast = &InsertAST{}
 
 	// Table
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect table after INSERT INTO", insert)
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
 	}
-	ast.Table = p.s.TokenText()
+	ast.Table = p.s.Token().Value
 
 	// ColNames
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect VALUES or (colNames)", insert)
+	if tok := p.s.Scan(); tok == TokenEOF {
+		return nil, p.expected(VALUES, "(")
 	}
 
 	txt := strings.ToUpper(p.s.TokenText())
 	if txt != VALUES {
 		if txt != "(" {
-			return nil, fmt.Errorf("%s expect VALUES or (colNames)", insert)
+			return nil, p.expected(VALUES, "(")
 		}
 
 		columns, err := p.scanColumns(&p.s)
@@ -117,108 +265,326 @@ func (p *Parser) ParseInsert(insert string) (ast *InsertAST, err error) {
 		ast.Columns = columns
 
 		if !p.scanAndCheck(&p.s, VALUES) {
-			return nil, fmt.Errorf("%s expect VALUES", insert)
+			return nil, p.expected(VALUES)
 		}
 	}
 
 	// Values
 	columnCnt := len(ast.Columns)
+	var starts []Token // the ( of every row
 	// VALUES has been scanned try to get (value1, value2), (value3, value4)
-	if columnCnt != 0 {
-		ast.Values = make([][]string, 0, columnCnt)
-	} else {
-		ast.Values = make([][]string, 0, 8)
-	}
-
 	for {
-		if tok := p.s.Scan(); tok == scanner.EOF {
+		if tok := p.s.Scan(); tok == TokenEOF {
 			break
 		}
 
 		txt := p.s.TokenText()
-		if txt == "," {
+		if txt == "," || txt == ";" {
 			continue // next row
 		}
-		if txt == "(" {
-			row, err := p.scanColumns(&p.s)
-			if err != nil {
-				return nil, err
-			}
-			ast.Values = append(ast.Values, row)
-		} else {
-			return nil, fmt.Errorf("%s expected (", insert)
+		if txt != "(" {
+			return nil, p.expected("(", ",")
+		}
+		starts = append(starts, p.s.Token())
+		row, err := p.scanValues(&p.s)
+		if err != nil {
+			return nil, err
 		}
+		ast.Values = append(ast.Values, row)
+	}
+	if len(ast.Values) == 0 {
+		return nil, p.expected("(")
 	}
 
 	// Check if column count identical
-	for _, row := range ast.Values {
+	for i, row := range ast.Values {
 		if columnCnt == 0 {
 			columnCnt = len(ast.Values[0]) // compare with first row
 		}
 		if columnCnt != len(row) {
-			err = fmt.Errorf(
-				"%s expected column count is %d, got %d, %v",
-				insert, columnCnt, len(row), row,
-			)
-			return
+			err = &ParseError{SQL: insert, Position: starts[i].Pos, Found: starts[i],
+				Msg: fmt.Sprintf("expected %d values, got %d", columnCnt, len(row))}
+			return nil, err
 		}
 	}
 
 	return
 }
 
-func (p *Parser) scanAndCheck(s *scanner.Scanner, target string) bool {
+// scanValues scans "value1, value2)" after the ( of a VALUES row
+func (p *Parser) scanValues(s *Lexer) ([]Expr, error) {
+	var tokens []Token
+	for depth := 0; ; {
+		if tok := s.Scan(); tok == TokenEOF {
+			return nil, p.expected(")")
+		}
+		txt := s.TokenText()
+		if txt == "(" {
+			depth++
+		} else if txt == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		tokens = append(tokens, s.Token())
+	}
+
+	var row []Expr
+	for _, value := range splitTopLevel(tokens) {
+		e, err := p.parseExpr(value)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, e)
+	}
+	return row, nil
+}
+
+func (p *Parser) scanAndCheck(s *Lexer, target string) bool {
 	tok := s.Scan()
-	return tok != scanner.EOF && strings.ToUpper(s.TokenText()) == target
+	return tok != TokenEOF && strings.ToUpper(s.TokenText()) == target
 }
 
 // (col1,col2,col3)
-func (p *Parser) scanColumns(s *scanner.Scanner) ([]string, error) {
+func (p *Parser) scanColumns(s *Lexer) ([]string, error) {
 	columns := make([]string, 0, 8)
 
 	for {
-		if tok := s.Scan(); tok == scanner.EOF {
-			return nil, fmt.Errorf("get Columns failed")
+		if tok := s.Scan(); tok == TokenEOF {
+			return nil, p.expected(")")
 		}
 		txt := s.TokenText()
 		if txt == "," || txt == "(" {
 			continue
 		} else if txt == ")" {
 			break
+		} else if s.Token().isName() {
+			columns = append(columns, s.Token().Value)
 		} else {
-			columns = append(columns, txt)
+			return nil, p.expected("column")
 		}
 	}
 
 	return columns, nil
 }
 
-func (p *Parser) ScanWhere(s *scanner.Scanner) ([]string, string, error) {
-	var where []string
-	var lastToken string
+// scanClause returns the tokens up to one of the stop keywords or EOF, and the keyword.
+// A keyword next to a dot is a qualified column, eg. order.id or o.left
+func (p *Parser) scanClause(s *Lexer, stops ...string) (tokens []Token, stop string) {
 	for {
-		if tok := s.Scan(); tok == scanner.EOF {
-			if len(where) == 0 {
-				return nil, lastToken, fmt.Errorf("missing WHERE clause")
+		if tok := s.Scan(); tok == TokenEOF {
+			return tokens, ""
+		}
+		tok := s.Token()
+		qualified := s.Peek().Text == "." || len(tokens) > 0 && tokens[len(tokens)-1].Text == "."
+		for _, keyword := range stops {
+			if tok.Keyword() == keyword && !qualified {
+				return tokens, keyword
 			}
-			return where, lastToken, nil
 		}
-		txt := p.s.TokenText()
-		if strings.ToUpper(txt) == LIMIT {
-			lastToken = LIMIT
-			break
+		if tok.Text != ";" {
+			tokens = append(tokens, tok)
+		}
+	}
+}
+
+// scanAlias scans "[AS] alias" after a table, it returns the keyword after them
+func (p *Parser) scanAlias(s *Lexer) (alias string, keyword string, err error) {
+	if tok := s.Scan(); tok == TokenEOF {
+		return "", "", nil
+	}
+	txt := strings.ToUpper(s.TokenText())
+	switch txt {
+	case AS:
+		if s.Scan(); !s.Token().isName() {
+			return "", "", p.expected("alias")
+		}
+	case ON, JOIN, INNER, LEFT, WHERE, GROUP, HAVING, ORDER, LIMIT, ";":
+		return "", txt, nil
+	default:
+		if !s.Token().isName() {
+			return "", "", p.expected("alias", JOIN, WHERE, GROUP, ORDER, LIMIT)
+		}
+	}
+	alias = strings.ToLower(s.Token().Value)
+
+	if tok := s.Scan(); tok == TokenEOF {
+		return alias, "", nil
+	}
+	return alias, strings.ToUpper(s.TokenText()), nil
+}
+
+/*
+scanJoins scans the alias of the FROM table and the joined tables, eg.
+
+	FROM user u JOIN order o ON u.id = o.user_id LEFT JOIN address ON ...
+
+It returns the keyword after them.
+*/
+func (p *Parser) scanJoins(s *Lexer, ast *SelectAST) (keyword string, err error) {
+	if ast.Alias, keyword, err = p.scanAlias(s); err != nil {
+		return "", err
+	}
+	for {
+		join := &JoinClause{}
+		switch keyword {
+		case JOIN:
+		case INNER:
+			if !p.scanAndCheck(s, JOIN) {
+				return "", p.expected(JOIN)
+			}
+		case LEFT:
+			join.Left = true
+			if tok := s.Scan(); tok != TokenEOF && strings.ToUpper(s.TokenText()) == OUTER {
+				s.Scan()
+			}
+			if strings.ToUpper(s.TokenText()) != JOIN {
+				return "", p.expected(JOIN)
+			}
+		case ";":
+			return "", nil
+		default:
+			return keyword, nil
+		}
+
+		if s.Scan(); !s.Token().isName() {
+			return "", p.expected("table")
 		}
-		if txt != ";" {
-			where = append(where, txt)
+		join.Table = strings.ToLower(s.Token().Value)
+		if join.Alias, keyword, err = p.scanAlias(s); err != nil {
+			return "", err
 		}
+		if keyword != ON {
+			return "", p.expected(ON)
+		}
+
+		var tokens []Token
+		tokens, keyword = p.scanClause(s, JOIN, INNER, LEFT, WHERE, GROUP, HAVING, ORDER, LIMIT)
+		if len(tokens) == 0 {
+			return "", p.expected("expression")
+		}
+		if join.On, err = p.parseExpr(tokens); err != nil {
+			return "", err
+		}
+		ast.Joins = append(ast.Joins, join)
+	}
+}
+
+// ScanWhere scans the WHERE clause up to the next clause and parses it into an expression
+func (p *Parser) ScanWhere(s *Lexer) (Expr, string, error) {
+	tokens, lastToken := p.scanClause(s, GROUP, HAVING, ORDER, LIMIT)
+	if len(tokens) == 0 {
+		return nil, lastToken, p.expected("expression")
+	}
+	where, err := p.parseExpr(tokens)
+	if err != nil {
+		return nil, lastToken, err
 	}
 	return where, lastToken, nil
 }
 
+// ScanGroupBy scans "expr, ..." after GROUP BY
+func (p *Parser) ScanGroupBy(s *Lexer) ([]Expr, string, error) {
+	tokens, lastToken := p.scanClause(s, HAVING, ORDER, LIMIT)
+	var groupBy []Expr
+	for _, term := range splitTopLevel(tokens) {
+		e, err := p.parseExpr(term)
+		if err != nil {
+			return nil, lastToken, err
+		}
+		groupBy = append(groupBy, e)
+	}
+	if len(groupBy) == 0 {
+		return nil, lastToken, p.expected("expression")
+	}
+	return groupBy, lastToken, nil
+}
+
+func (p *Parser) ScanHaving(s *Lexer) (Expr, string, error) {
+	tokens, lastToken := p.scanClause(s, ORDER, LIMIT)
+	if len(tokens) == 0 {
+		return nil, lastToken, p.expected("expression")
+	}
+	having, err := p.parseExpr(tokens)
+	if err != nil {
+		return nil, lastToken, err
+	}
+	return having, lastToken, nil
+}
+
+type OrderByTerm struct {
+	Expr Expr
+	Desc bool
+}
+
+// ScanOrderBy scans "expr [ASC|DESC], ..." after ORDER BY up to LIMIT
+func (p *Parser) ScanOrderBy(s *Lexer) ([]*OrderByTerm, string, error) {
+	tokens, lastToken := p.scanClause(s, LIMIT)
+	var orderBy []*OrderByTerm
+	for _, term := range splitTopLevel(tokens) {
+		desc := false
+		if n := len(term); n > 1 {
+			switch term[n-1].Keyword() {
+			case DESC:
+				desc = true
+				term = term[:n-1]
+			case ASC:
+				term = term[:n-1]
+			}
+		}
+		e, err := p.parseExpr(term)
+		if err != nil {
+			return nil, lastToken, err
+		}
+		orderBy = append(orderBy, &OrderByTerm{Expr: e, Desc: desc})
+	}
+	if len(orderBy) == 0 {
+		return nil, lastToken, p.expected("expression")
+	}
+	return orderBy, lastToken, nil
+}
+
+// splitTopLevel splits tokens at the commas which are not in parentheses
+func splitTopLevel(tokens []Token) [][]Token {
+	var parts [][]Token
+	depth, start := 0, 0
+	for i, tok := range tokens {
+		switch tok.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				parts = append(parts, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(tokens) {
+		parts = append(parts, tokens[start:])
+	}
+	return parts
+}
+
+type JoinClause struct {
+	Table string
+	Alias string
+	Left  bool // LEFT [OUTER] JOIN, otherwise [INNER] JOIN
+	On    Expr
+}
+
 type SelectAST struct {
 	Table    string
-	Projects []string
-	Where    []string
+	Alias    string
+	Joins    []*JoinClause
+	Projects []string // the source text of every projection, eg. username or COUNT(*)
+	Fields   []Expr   // the parsed projections, nil for *
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	OrderBy  []*OrderByTerm
 	Limit    int64
 }
 
@@ -227,82 +593,97 @@ ParseSelect is a simple select statement parser.
 It's just a demo of SELECT statement parser skeleton.
 Currently, the most complex SQL supported here is something like:
 
-	SELECT * FROM foo WHERE id < 3 LIMIT 1;
+	SELECT name, COUNT(*) FROM foo WHERE id < 3 GROUP BY name HAVING COUNT(*) > 1 ORDER BY name DESC LIMIT 1;
+	SELECT f.name, b.name FROM foo f LEFT JOIN bar b ON f.id = b.foo_id;
 
 Even SQL-92 standard is far more complex.
 For a production ready SQL parser, see: https://github.com/auxten/postgresql-parser
 */
 func (p *Parser) ParseSelect(sql string) (ast *SelectAST, err error) {
-	p.s.Init(strings.NewReader(sql))
-	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
 
 	if !p.scanAndCheck(&p.s, SELECT) {
-		err = fmt.Errorf("%s is not SELECT statement", sql)
+		err = p.expected(SELECT)
 		return
 	}
 
 	ast = &SelectAST{Projects: make([]string, 0, 4)}
 
-	for {
-		if tok := p.s.Scan(); tok == scanner.EOF {
-			if len(ast.Projects) == 0 {
-				err = fmt.Errorf("%s get select projects failed", sql)
-			}
-			return
-		} else {
-			txt := p.s.TokenText()
-			if txt == ASTERISK {
-				ast.Projects = append(ast.Projects, ASTERISK)
-			} else {
-				if txt == "," {
-					continue
-				} else if strings.ToUpper(txt) == FROM {
-					break
-				} else {
-					ast.Projects = append(ast.Projects, strings.ToLower(txt))
-				}
-			}
+	tokens, stop := p.scanClause(&p.s, FROM)
+	for _, project := range splitTopLevel(tokens) {
+		if len(project) == 1 && project[0].Text == ASTERISK {
+			ast.Projects = append(ast.Projects, ASTERISK)
+			ast.Fields = append(ast.Fields, nil)
+			continue
 		}
+		field, err := p.parseExpr(project)
+		if err != nil {
+			return nil, err
+		}
+		first, last := project[0], project[len(project)-1]
+		ast.Projects = append(ast.Projects, p.sql[first.Pos.Offset:last.Pos.Offset+len(last.Text)])
+		ast.Fields = append(ast.Fields, field)
+	}
+	if len(ast.Projects) == 0 {
+		return nil, p.expected("expression", "*")
 	}
 
 	// token FROM is scanned, try to get the table name here
 	// FROM ?
-	if tok := p.s.Scan(); tok == scanner.EOF {
+	if stop != FROM {
 		// if projects are all constant value, source table is not necessary.
 		// eg.  SELECT 1;
 		return
-	} else {
-		ast.Table = strings.ToLower(p.s.TokenText())
 	}
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
+	}
+	ast.Table = strings.ToLower(p.s.Token().Value)
 
-	// WHERE
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		// WHERE/Limit is not necessary
-		return
+	// [alias] [JOIN ...], WHERE/Limit is not necessary
+	txt, err := p.scanJoins(&p.s, ast)
+	if err != nil {
+		return nil, err
 	}
 
-	txt := p.s.TokenText()
-	txt = strings.ToUpper(txt)
-	var lastToken string
 	if txt == WHERE {
 		// token WHERE is scanned, try to get the WHERE clause.
-		ast.Where, lastToken, err = p.ScanWhere(&p.s)
-		if err != nil {
+		if ast.Where, txt, err = p.ScanWhere(&p.s); err != nil {
 			return nil, err
 		}
-	} else if txt != LIMIT {
-		err = fmt.Errorf("expect WHERE or LIMIT here")
-		return
 	}
 
-	if lastToken == LIMIT || txt == LIMIT {
-		// token LIMIT is scanned, try to get the limit
-		if tok := p.s.Scan(); tok == scanner.EOF {
-			err = fmt.Errorf("expect LIMIT clause here")
-			return
+	if txt == GROUP {
+		if !p.scanAndCheck(&p.s, BY) {
+			return nil, p.expected(BY)
+		}
+		if ast.GroupBy, txt, err = p.ScanGroupBy(&p.s); err != nil {
+			return nil, err
 		}
-		txt = p.s.TokenText()
-		ast.Limit, err = strconv.ParseInt(txt, 10, 64)
+	}
+
+	if txt == HAVING {
+		if ast.Having, txt, err = p.ScanHaving(&p.s); err != nil {
+			return nil, err
+		}
+	}
+
+	if txt == ORDER {
+		if !p.scanAndCheck(&p.s, BY) {
+			return nil, p.expected(BY)
+		}
+		if ast.OrderBy, txt, err = p.ScanOrderBy(&p.s); err != nil {
+			return nil, err
+		}
+	}
+
+	if txt == LIMIT {
+		// token LIMIT is scanned, try to get the limit
+		ast.Limit, err = p.scanLimit(&p.s)
+	} else if txt != "" {
+		err = p.expected(WHERE, "GROUP BY", HAVING, "ORDER BY", LIMIT)
 	}
 
 	return
@@ -311,30 +692,31 @@ func (p *Parser) ParseSelect(sql string) (ast *SelectAST, err error) {
 type UpdateAST struct {
 	Table    string
 	Columns  []string
-	NewValue []string
-	Where    []string
+	NewValue []Expr // evaluated against the old row, eg. n = n + 1
+	Where    Expr
 	Limit    int64
 }
 
 func (p *Parser) ParseUpdate(sql string) (ast *UpdateAST, err error) {
-	p.s.Init(strings.NewReader(sql))
-	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
 
 	if !p.scanAndCheck(&p.s, UPDATE) {
-		err = fmt.Errorf("%s is not UPDATE statement", sql)
+		err = p.expected(UPDATE)
 		return
 	}
 
 	ast = &UpdateAST{}
 
 	// Table
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect table after Update", sql)
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
 	}
-	ast.Table = p.s.TokenText()
+	ast.Table = p.s.Token().Value
 
 	if !p.scanAndCheck(&p.s, Set) {
-		return nil, fmt.Errorf("expect INTO after INSERT")
+		return nil, p.expected(Set)
 	}
 
 	var lastToken string
@@ -347,96 +729,108 @@ func (p *Parser) ParseUpdate(sql string) (ast *UpdateAST, err error) {
 	return ast, err
 }
 
-func (p *Parser) ScanSet(s *scanner.Scanner) ([]string, []string, string, error) {
+// ScanSet scans "col = expr, ..." after SET up to WHERE or LIMIT
+func (p *Parser) ScanSet(s *Lexer) ([]string, []Expr, string, error) {
+	tokens, lastToken := p.scanClause(s, WHERE, LIMIT)
 	var cols []string
-	var vals []string
-	var lastToken string
-	for {
-		if tok := s.Scan(); tok == scanner.EOF {
-			if len(cols) == 0 {
-				return cols, vals, lastToken, fmt.Errorf("missing WHERE clause")
-			}
-			return cols, vals, lastToken, nil
+	var vals []Expr
+	for _, term := range splitTopLevel(tokens) {
+		if !term[0].isName() {
+			return cols, vals, lastToken, p.errorAt(term[0], "column")
 		}
-
-		txt := strings.ToUpper(p.s.TokenText())
-		if txt == "," {
-			continue
-		} else if txt == WHERE || txt == LIMIT {
-			lastToken = txt
-			break
+		if len(term) < 2 || term[1].Text != "=" {
+			return cols, vals, lastToken, p.errorAt(tokenAt(term, 1), "=")
 		}
-
-		col := txt
-
-		if !p.scanAndCheck(s, "=") {
-			return cols, vals, lastToken, fmt.Errorf("expect = in sql")
+		if len(term) < 3 {
+			return cols, vals, lastToken, p.errorAt(tokenAt(term, 2), "expression")
 		}
-
-		if tok := p.s.Scan(); tok == scanner.EOF {
-			return cols, vals, lastToken, fmt.Errorf("expect new value after =")
+		val, err := p.parseExpr(term[2:])
+		if err != nil {
+			return cols, vals, lastToken, err
 		}
-		newValue := p.s.TokenText()
-
-		cols = append(cols, col)
-		vals = append(vals, newValue)
+		cols = append(cols, term[0].Value)
+		vals = append(vals, val)
+	}
+	if len(cols) == 0 {
+		return cols, vals, lastToken, p.expected("column")
 	}
-
 	return cols, vals, lastToken, nil
 }
 
-func (p *Parser) ScanWhereAndLimit(s *scanner.Scanner, lastToken string) (where []string, limit int64, err error) {
+func (p *Parser) ScanWhereAndLimit(s *Lexer, lastToken string) (where Expr, limit int64, err error) {
 	var last string
 	if lastToken == WHERE {
 		where, last, err = p.ScanWhere(s)
 		if err != nil {
 			return
 		}
+		if last != "" && last != LIMIT {
+			err = p.errorf("%s is only supported in SELECT", last)
+			return
+		}
 	} else if lastToken != LIMIT {
-		err = fmt.Errorf("expect WHERE or LIMIT here")
+		err = p.expected(WHERE, LIMIT)
 		return
 	}
 
 	if lastToken == LIMIT || last == LIMIT {
-		if tok := s.Scan(); tok == scanner.EOF {
-			err = fmt.Errorf("expect LIMIT clause here")
-			return
-		}
-		txt := s.TokenText()
-		limit, err = strconv.ParseInt(txt, 10, 64)
+		limit, err = p.scanLimit(s)
 	}
 	return
 }
 
+// scanLimit scans the number after LIMIT, which ends the statement
+func (p *Parser) scanLimit(s *Lexer) (int64, error) {
+	s.Scan()
+	limit, err := strconv.ParseInt(s.TokenText(), 10, 64)
+	if s.Token().Kind != TokenNumber || err != nil {
+		return 0, p.expected("number")
+	}
+	s.Scan()
+	return limit, p.end(s)
+}
+
+// end checks that the statement ends at the current token
+func (p *Parser) end(s *Lexer) error {
+	if s.TokenText() == ";" {
+		s.Scan()
+	}
+	if s.Token().Kind != TokenEOF {
+		return p.expected("end of statement")
+	}
+	return nil
+}
+
 type DeleteAST struct {
 	Table string
-	Where []string
+	Where Expr
 	Limit int64
 }
 
 func (p *Parser) ParseDelete(sql string) (ast *DeleteAST, err error) {
-	p.s.Init(strings.NewReader(sql))
-	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
 
 	if !p.scanAndCheck(&p.s, DELETE) {
-		err = fmt.Errorf("%s is not DELETE statement", sql)
+		err = p.expected(DELETE)
 		return
 	}
 	if !p.scanAndCheck(&p.s, FROM) {
-		err = fmt.Errorf("%s is not FROM statement", sql)
+		err = p.expected(FROM)
 		return
 	}
 
 	ast = &DeleteAST{}
 
 	// Table
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect table after Update", sql)
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
 	}
-	ast.Table = p.s.TokenText()
+	ast.Table = p.s.Token().Value
 
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect table after Update", sql)
+	if tok := p.s.Scan(); tok == TokenEOF {
+		return nil, p.expected(WHERE, LIMIT)
 	}
 	lastToken := strings.ToUpper(p.s.TokenText())
 	ast.Where, ast.Limit, err = p.ScanWhereAndLimit(&p.s, lastToken)
@@ -444,188 +838,437 @@ func (p *Parser) ParseDelete(sql string) (ast *DeleteAST, err error) {
 }
 
 type CreateTableAST struct {
-	Table      string
-	PrimaryKey string
-	Columns    []string
-	Type       []string
-	NotNull    []bool
-	Default    []string
+	Table       string
+	IfNotExists bool
+	PrimaryKey  []string // the columns of PRIMARY KEY (a, b)
+	Columns     []string
+	Type        []string
+	NotNull     []bool
+	Default     []string
 }
 
+// ParseCreateTable parses CREATE TABLE [IF NOT EXISTS] table_name (column definitions, PRIMARY KEY (column, ...))
 func (p *Parser) ParseCreateTable(sql string) (ast *CreateTableAST, err error) {
-	p.s.Init(strings.NewReader(sql))
-	p.s.Mode = scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
 
 	if !p.scanAndCheck(&p.s, CREATE) {
-		err = fmt.Errorf("%s is not CREATE TABLE statement", sql)
+		err = p.expected(CREATE)
 		return
 	}
 	if !p.scanAndCheck(&p.s, TABLE) {
-		err = fmt.Errorf("%s is not CREATE TABLE statement", sql)
+		err = p.expected(TABLE)
 		return
 	}
 
 	ast = &CreateTableAST{}
+	if p.s.Scan(); strings.ToUpper(p.s.TokenText()) == IF {
+		if !p.scanAndCheck(&p.s, "NOT") {
+			return nil, p.expected("NOT")
+		}
+		if !p.scanAndCheck(&p.s, EXISTS) {
+			return nil, p.expected(EXISTS)
+		}
+		ast.IfNotExists = true
+		p.s.Scan()
+	}
 
 	// Table
-	if tok := p.s.Scan(); tok == scanner.EOF {
-		return nil, fmt.Errorf("%s expect table after Update", sql)
+	if !p.s.Token().isName() {
+		return nil, p.expected("table")
 	}
-	ast.Table = p.s.TokenText()
+	ast.Table = p.s.Token().Value
 
 	if !p.scanAndCheck(&p.s, "(") {
-		err = fmt.Errorf("%s is not CREATE TABLE statement", sql)
+		err = p.expected("(")
 		return
 	}
 	ast.PrimaryKey, ast.Columns, ast.Type, ast.NotNull, ast.Default, err = p.ScanTable(&p.s)
 	return
 }
 
-func (p *Parser) ScanTable(s *scanner.Scanner) (
-	PrimaryKey string, Columns []string, Type []string, NotNull []bool, Default []string, err error) {
+func (p *Parser) ScanTable(s *Lexer) (
+	PrimaryKey []string, Columns []string, Type []string, NotNull []bool, Default []string, err error) {
 	for {
-		if tok := s.Scan(); tok == scanner.EOF {
-			if len(Columns) == 0 {
-				err = fmt.Errorf("missing Columns")
-				return
-			}
-			return
-		}
-
-		txt := p.s.TokenText()
-
-		if txt == ")" || txt == ";" {
-			break
-		}
-
-		if txt == PRIMARY {
+		s.Scan()
+		if strings.ToUpper(s.TokenText()) == PRIMARY {
 			if !p.scanAndCheck(s, KEY) {
-				err = fmt.Errorf("primary key err")
+				err = p.expected(KEY)
 				return
 			}
 			if !p.scanAndCheck(&p.s, "(") {
-				err = fmt.Errorf("err in primary key")
+				err = p.expected("(")
 				return
 			}
-			if tok := s.Scan(); tok == scanner.EOF {
-				err = fmt.Errorf("expect primary key here")
+			for {
+				if s.Scan(); !s.Token().isName() {
+					err = p.expected("column")
+					return
+				}
+				PrimaryKey = append(PrimaryKey, s.Token().Value)
+				if s.Scan(); s.TokenText() != "," {
+					break
+				}
+			}
+			if s.TokenText() != ")" {
+				err = p.expected(",", ")")
 				return
 			}
-			PrimaryKey = s.TokenText()
-			if !p.scanAndCheck(&p.s, ")") {
-				err = fmt.Errorf("is err in VARCHAR")
+			s.Scan()
+		} else {
+			_col, _type, _notNull, _default, _err := p.scanColInTable(s)
+			if _err != nil {
+				err = _err
 				return
 			}
-			continue
+			Columns = append(Columns, _col)
+			Type = append(Type, _type)
+			NotNull = append(NotNull, _notNull)
+			Default = append(Default, _default)
 		}
 
-		_col, _type, _notNull, _default, _err := p.scanColInTable(&p.s, txt)
-		if _err != nil {
-			err = _err
+		switch s.TokenText() {
+		case ",":
+		case ")":
+			s.Scan()
+			err = p.end(s)
+			return
+		default:
+			err = p.expected(",", ")")
 			return
 		}
-		Columns = append(Columns, _col)
-		Type = append(Type, _type)
-		NotNull = append(NotNull, _notNull)
-		Default = append(Default, _default)
 	}
-
-	return
 }
 
-func (p *Parser) scanColInTable(s *scanner.Scanner, startToken string) (
+// scanColInTable scans "col type [NOT NULL] [DEFAULT value]", the token after them is the current one
+func (p *Parser) scanColInTable(s *Lexer) (
 	col string, Type string, notNull bool, Default string, err error) {
 
-	var token = startToken
-	for {
-		if token == "," || token == ")" {
-			if col == "" || Type == "" {
-				err = fmt.Errorf("missing column clause")
-				return
-			}
+	if !s.Token().isName() {
+		err = p.expected("column")
+		return
+	}
+	col = s.Token().Value
+
+	s.Scan()
+	var ok bool
+	Type, ok = p.checkType(s.TokenText())
+	if !ok {
+		err = p.expected(columnTypes...)
+		return
+	}
+
+	if Type == "VARCHAR" {
+		var length int64
+		if !p.scanAndCheck(s, "(") {
+			err = p.expected("(")
 			return
 		}
-		col = p.s.TokenText()
-
-		if tok := s.Scan(); tok == scanner.EOF {
+		s.Scan()
+		if length, err = strconv.ParseInt(s.TokenText(), 10, 10); err != nil {
+			err = p.expected("length")
 			return
 		}
-
-		var ok bool
-		token = p.s.TokenText()
-		Type, ok = p.checkType(token)
-		if !ok {
-			err = fmt.Errorf("check type failed")
+		if !p.scanAndCheck(s, ")") {
+			err = p.expected(")")
 			return
 		}
+		Type = fmt.Sprintf("VARCHAR(%d)", length)
+	}
 
-		if Type == "VARCHAR" {
-			var length int64
-			if !p.scanAndCheck(&p.s, "(") {
-				err = fmt.Errorf("is err in VARCHAR")
-				return
-			}
-			if tok := s.Scan(); tok == scanner.EOF {
-				err = fmt.Errorf("expect VARCHAR length clause here")
+	// NUMERIC is DECIMAL, the precision is 10 and the scale is 0 if they are omitted
+	if Type == "DECIMAL" || Type == "NUMERIC" {
+		precision, scale := int64(10), int64(0)
+		if s.Peek().Text == "(" {
+			s.Scan()
+			s.Scan()
+			if precision, err = strconv.ParseInt(s.TokenText(), 10, 10); err != nil || precision < 1 || precision > maxPrecision {
+				err = p.expected("precision")
 				return
 			}
-			txt := s.TokenText()
-			if length, err = strconv.ParseInt(txt, 10, 10); err != nil {
-				err = fmt.Errorf("expect VARCHAR length clause here")
-				return
+			if s.Scan(); s.TokenText() == "," {
+				s.Scan()
+				if scale, err = strconv.ParseInt(s.TokenText(), 10, 10); err != nil || scale > precision {
+					err = p.expected("scale")
+					return
+				}
+				s.Scan()
 			}
-			if !p.scanAndCheck(&p.s, ")") {
-				err = fmt.Errorf("is err in VARCHAR")
+			if s.TokenText() != ")" {
+				err = p.expected(",", ")")
 				return
 			}
-			Type = fmt.Sprintf("VARCHAR(%d)", length)
-		}
-
-		if tok := s.Scan(); tok == scanner.EOF {
-			err = fmt.Errorf("expect NOT NULL/Default value clause here")
-			return
 		}
+		Type = fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+	}
 
-		token = strings.ToLower(s.TokenText())
-		if token == NOT {
-			if !p.scanAndCheck(&p.s, NULL) {
-				err = fmt.Errorf("is err in not null")
+	for {
+		s.Scan()
+		switch strings.ToUpper(s.TokenText()) {
+		case NULL:
+		case "NOT":
+			if !p.scanAndCheck(s, NULL) {
+				err = p.expected(NULL)
 				return
 			}
 			notNull = true
-		}
-
-		if tok := s.Scan(); tok == scanner.EOF {
-			return
-		}
-
-		token = strings.ToUpper(s.TokenText())
-		if token == "," {
-			return
-		}
-		if token == DEFAULT {
-			if tok := s.Scan(); tok == scanner.EOF {
-				err = fmt.Errorf("default value is null")
+		case DEFAULT:
+			switch s.Scan() {
+			case TokenString, TokenQuotedIdent, TokenNumber, TokenBlob:
+				Default = s.TokenText()
+			case TokenKeyword:
+				switch keyword := s.Token().Keyword(); keyword {
+				case NULL, "TRUE", "FALSE", "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP":
+					Default = keyword
+				default:
+					err = p.expected("value")
+					return
+				}
+			case TokenOperator:
+				if s.TokenText() != "-" || s.Scan() != TokenNumber {
+					err = p.expected("value")
+					return
+				}
+				Default = "-" + s.TokenText()
+			default:
+				err = p.expected("value")
 				return
 			}
-			Default = s.TokenText()
-		}
-
-		if tok := s.Scan(); tok == scanner.EOF {
+		default:
 			return
 		}
-		token = s.TokenText()
 	}
-	return
 }
 
+type CreateIndexAST struct {
+	Name   string
+	Table  string
+	Column string
+	Unique bool
+}
+
+/*
+ParseCreateIndex parses a single column index, eg.
+
+	CREATE [UNIQUE] INDEX index_name ON table_name (column)
+*/
+func (p *Parser) ParseCreateIndex(sql string) (ast *CreateIndexAST, err error) {
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
+
+	if !p.scanAndCheck(&p.s, CREATE) {
+		err = p.expected(CREATE)
+		return
+	}
+
+	ast = &CreateIndexAST{}
+
+	if tok := p.s.Scan(); tok == TokenEOF {
+		return nil, p.expected(INDEX, UNIQUE)
+	}
+	if strings.ToUpper(p.s.TokenText()) == UNIQUE {
+		ast.Unique = true
+		p.s.Scan()
+	}
+	if strings.ToUpper(p.s.TokenText()) != INDEX {
+		return nil, p.expected(INDEX)
+	}
+
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("index name")
+	}
+	ast.Name = p.s.Token().Value
+
+	if !p.scanAndCheck(&p.s, ON) {
+		return nil, p.expected(ON)
+	}
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
+	}
+	ast.Table = p.s.Token().Value
+
+	if !p.scanAndCheck(&p.s, "(") {
+		return nil, p.expected("(")
+	}
+	columns, err := p.scanColumns(&p.s)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != 1 {
+		return nil, p.errorf("only single column index is supported")
+	}
+	ast.Column = strings.ToLower(columns[0])
+	return ast, nil
+}
+
+// columnTypes are the types of CREATE TABLE, VARCHAR is followed by its length
+var columnTypes = []string{"INTEGER", "VARCHAR", "REAL", "DOUBLE", "BOOLEAN", "TEXT", "BLOB", "DATE", "TIME", "TIMESTAMP", "DECIMAL", "NUMERIC"}
+
+// maxPrecision is the most digits of a DECIMAL
+const maxPrecision = 65
+
 func (p *Parser) checkType(Type string) (string, bool) {
 	Type = strings.ToUpper(Type)
 
-	for _, t := range []string{"INTEGER", "VARCHAR"} {
+	for _, t := range columnTypes {
 		if t == Type {
 			return Type, true
 		}
 	}
 	return Type, false
 }
+
+type DropTableAST struct {
+	Table    string
+	IfExists bool
+}
+
+// ParseDropTable parses DROP TABLE [IF EXISTS] table_name
+func (p *Parser) ParseDropTable(sql string) (ast *DropTableAST, err error) {
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
+	if !p.scanAndCheck(&p.s, DROP) {
+		return nil, p.expected(DROP)
+	}
+	if !p.scanAndCheck(&p.s, TABLE) {
+		return nil, p.expected(TABLE)
+	}
+
+	ast = &DropTableAST{}
+	if p.s.Scan(); strings.ToUpper(p.s.TokenText()) == IF {
+		if !p.scanAndCheck(&p.s, EXISTS) {
+			return nil, p.expected(EXISTS)
+		}
+		ast.IfExists = true
+		p.s.Scan()
+	}
+	if !p.s.Token().isName() {
+		return nil, p.expected("table")
+	}
+	ast.Table = p.s.Token().Value
+
+	p.s.Scan()
+	return ast, p.end(&p.s)
+}
+
+type TruncateAST struct {
+	Table string
+}
+
+// ParseTruncate parses TRUNCATE [TABLE] table_name
+func (p *Parser) ParseTruncate(sql string) (ast *TruncateAST, err error) {
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
+	if !p.scanAndCheck(&p.s, TRUNCATE) {
+		return nil, p.expected(TRUNCATE)
+	}
+
+	if p.s.Scan(); strings.ToUpper(p.s.TokenText()) == TABLE {
+		p.s.Scan()
+	}
+	if !p.s.Token().isName() {
+		return nil, p.expected("table")
+	}
+	ast = &TruncateAST{Table: p.s.Token().Value}
+
+	p.s.Scan()
+	return ast, p.end(&p.s)
+}
+
+// AlterAction is what ALTER TABLE does
+type AlterAction string
+
+const (
+	AddColumn    AlterAction = "ADD COLUMN"
+	DropColumn   AlterAction = "DROP COLUMN"
+	RenameColumn AlterAction = "RENAME COLUMN"
+	RenameTable  AlterAction = "RENAME TO"
+)
+
+type AlterTableAST struct {
+	Table   string
+	Action  AlterAction
+	Column  string // the added, dropped or renamed column
+	NewName string // the new name of the column or the table
+
+	// the definition of the added column
+	Type    string
+	NotNull bool
+	Default string
+}
+
+/*
+ParseAlterTable parses an ALTER TABLE statement, eg.
+
+	ALTER TABLE table_name ADD [COLUMN] column INTEGER [NOT NULL] [DEFAULT 0]
+	ALTER TABLE table_name DROP [COLUMN] column
+	ALTER TABLE table_name RENAME [COLUMN] column TO new_column
+	ALTER TABLE table_name RENAME TO new_table_name
+*/
+func (p *Parser) ParseAlterTable(sql string) (ast *AlterTableAST, err error) {
+	if err = p.init(sql); err != nil {
+		return nil, err
+	}
+	if !p.scanAndCheck(&p.s, ALTER) {
+		return nil, p.expected(ALTER)
+	}
+	if !p.scanAndCheck(&p.s, TABLE) {
+		return nil, p.expected(TABLE)
+	}
+	if p.s.Scan(); !p.s.Token().isName() {
+		return nil, p.expected("table")
+	}
+	ast = &AlterTableAST{Table: p.s.Token().Value}
+
+	// the action, COLUMN is optional
+	p.s.Scan()
+	action := strings.ToUpper(p.s.TokenText())
+	switch action {
+	case ADD, DROP, RENAME:
+	default:
+		return nil, p.expected(ADD, DROP, RENAME)
+	}
+	p.s.Scan()
+	if action == RENAME && strings.ToUpper(p.s.TokenText()) == TO {
+		ast.Action = RenameTable
+	} else if strings.ToUpper(p.s.TokenText()) == COLUMN {
+		p.s.Scan()
+	}
+
+	switch action {
+	case ADD:
+		ast.Action = AddColumn
+		ast.Column, ast.Type, ast.NotNull, ast.Default, err = p.scanColInTable(&p.s)
+		if err != nil {
+			return nil, err
+		}
+	case DROP:
+		ast.Action = DropColumn
+		if !p.s.Token().isName() {
+			return nil, p.expected("column")
+		}
+		ast.Column = p.s.Token().Value
+		p.s.Scan()
+	case RENAME:
+		if ast.Action != RenameTable {
+			ast.Action = RenameColumn
+			if !p.s.Token().isName() {
+				return nil, p.expected("column")
+			}
+			ast.Column = p.s.Token().Value
+			if !p.scanAndCheck(&p.s, TO) {
+				return nil, p.expected(TO)
+			}
+		}
+		if p.s.Scan(); !p.s.Token().isName() {
+			return nil, p.expected("name")
+		}
+		ast.NewName = p.s.Token().Value
+		p.s.Scan()
+	}
+	return ast, p.end(&p.s)
+}
@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	db := NewDB()
+	for _, c := range []struct {
+		sql      string
+		line     int
+		column   int
+		found    string
+		expected []string
+	}{
+		{`INSERT user VALUES (1)`, 1, 8, "user", []string{INTO}},
+		{`INSERT INTO VALUES (1)`, 1, 13, "VALUES", []string{"table"}},
+		{`INSERT INTO user (id) VALUES`, 1, 29, "", []string{"("}},
+		{`UPDATE user id = 1`, 1, 13, "id", []string{Set}},
+		{`UPDATE user SET id 1`, 1, 20, "1", []string{"="}},
+		{`UPDATE user SET id =`, 1, 21, "", []string{"expression"}},
+		{`DELETE FROM WHERE id = 1`, 1, 13, "WHERE", []string{"table"}},
+		{`DELETE FROM user`, 1, 17, "", []string{WHERE, LIMIT}},
+		{"SELECT id\nFROM user\nWHERE id > 1 LIMIT x", 3, 20, "x", []string{"number"}},
+		{`SELECT id FROM user LIMIT 1 2`, 1, 29, "2", []string{"end of statement"}},
+		{`SELECT id FROM user WHERE COUNT(id, ) > 1`, 1, 37, ")", []string{"expression"}},
+		{`SELECT id FROM user WHERE id IS 1`, 1, 33, "1", []string{"NOT", NULL}},
+		{`SELECT id FROM user WHERE d < d + INTERVAL 2 FORTNIGHTS`, 1, 46, "FORTNIGHTS", intervalUnits},
+		{`SELECT id FROM user u JOIN order o WHERE`, 1, 36, "WHERE", []string{ON}},
+		{`CREATE TABLE t (id MONEY)`, 1, 20, "MONEY", columnTypes},
+		{`CREATE TABLE t (n DECIMAL(5,6))`, 1, 29, "6", []string{"scale"}},
+		{`CREATE TABLE t (n NUMERIC(5 2))`, 1, 29, "2", []string{",", ")"}},
+		{`CREATE TABLE t (id INTEGER DEFAULT -x)`, 1, 37, "x", []string{"value"}},
+		{`CREATE TABLE t (id INTEGER, PRIMARY id)`, 1, 37, "id", []string{KEY}},
+		{`CREATE TABLE t (a INTEGER, b INTEGER, PRIMARY KEY (a b))`, 1, 54, "b", []string{",", ")"}},
+		{`CREATE INDEX ON user (id)`, 1, 14, "ON", []string{"index name"}},
+		{"-- comment\nVACUUM user", 2, 1, "VACUUM", []string{SELECT, INSERT, UPDATE, DELETE, CREATE, DROP, TRUNCATE, ALTER, BEGIN, COMMIT, ROLLBACK}},
+		{`CREATE TABLE t (id INTEGER id2 INTEGER)`, 1, 28, "id2", []string{",", ")"}},
+		{`DROP TABLE IF user`, 1, 15, "user", []string{EXISTS}},
+		{`ALTER TABLE user MODIFY id`, 1, 18, "MODIFY", []string{ADD, DROP, RENAME}},
+		{`ALTER TABLE user RENAME id name`, 1, 28, "name", []string{TO}},
+	} {
+		_, err := db.Prepare(c.sql)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: expect ParseError, got %v", c.sql, err)
+			continue
+		}
+		if perr.SQL != c.sql || perr.Line != c.line || perr.Column != c.column || perr.Found.Text != c.found || !reflect.DeepEqual(perr.Expected, c.expected) {
+			t.Errorf("%s: got %d:%d %q %v, want %d:%d %q %v", c.sql,
+				perr.Line, perr.Column, perr.Found.Text, perr.Expected, c.line, c.column, c.found, c.expected)
+		}
+		if c.found == "" && perr.Found.Kind != TokenEOF {
+			t.Errorf("%s: expect EOF, got %s", c.sql, perr.Found)
+		}
+	}
+
+	_, err := db.Prepare("SELECT id,\n\tname FROM user\n\tWHERE id == (1")
+	want := "3:16: expected \")\", found EOF\n\tWHERE id == (1\n\t              ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("got\n%v\nwant\n%s", err, want)
+	}
+
+	_, err = db.Prepare(`INSERT INTO user (id, username) VALUES (1, "a"), (2)`)
+	want = "1:50: expected 2 values, got 1\nINSERT INTO user (id, username) VALUES (1, \"a\"), (2)\n                                                 ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("got\n%v\nwant\n%s", err, want)
+	}
+}
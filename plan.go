@@ -2,34 +2,68 @@ package sqlite
 
 import (
 	"fmt"
-	"go/token"
-	"go/types"
+	"math"
 	"reflect"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 )
 
 type Plan struct {
-	table          *Table
-	UnFilteredPipe chan *BPItem
-	FilteredPipe   chan *BPItem
-	LimitedPipe    chan *BPItem
-	ErrorsPipe     chan error
-	Stop           chan struct{}
+	table   *Table
+	joined  []*Table // the tables of the JOIN clauses, in order
+	journal journal
 }
 
-func NewPlan(table *Table) (p *Plan) {
-	return &Plan{
-		table:          table,
-		UnFilteredPipe: make(chan *BPItem),
-		FilteredPipe:   make(chan *BPItem),
-		LimitedPipe:    make(chan *BPItem),
-		ErrorsPipe:     make(chan error, 1),
-		Stop:           make(chan struct{}, 1),
+// journal is told about every change before the change touches the tree
+type journal interface {
+	put(table *Table, key int64, val interface{}) error
+	remove(table *Table, key int64) error
+	// ddl logs a statement which changes the schema, undo reverts it
+	ddl(sql string, undo func()) error
+}
+
+func NewPlan(table *Table, joined ...*Table) (p *Plan) {
+	return &Plan{table: table, joined: joined}
+}
+
+// setRow writes the row to the clustered index and maintains the secondary indexes
+func (p *Plan) setRow(key int64, val interface{}) error {
+	if err := p.table.checkUnique(key, val.([]interface{})); err != nil {
+		return err
 	}
+	if p.journal != nil {
+		if err := p.journal.put(p.table, key, val); err != nil {
+			return err
+		}
+	}
+
+	tree := p.table.GetClusterIndex()
+	if old := tree.Get(key); old != nil {
+		p.table.removeIndexEntries(key, old.([]interface{}))
+	}
+	tree.Set(key, val)
+	p.table.addIndexEntries(key, val.([]interface{}))
+	return nil
 }
 
-func (p *Plan) Delete(ast *DeleteAST) error {
+func (p *Plan) removeRow(key int64) error {
+	if p.journal != nil {
+		if err := p.journal.remove(p.table, key); err != nil {
+			return err
+		}
+	}
+
+	tree := p.table.GetClusterIndex()
+	if old := tree.Get(key); old != nil {
+		p.table.removeIndexEntries(key, old.([]interface{}))
+	}
+	tree.Remove(key)
+	return nil
+}
+
+// Delete removes the rows of WHERE and returns their number
+func (p *Plan) Delete(ast *DeleteAST) (int64, error) {
 	queryAST := &SelectAST{
 		Table:    ast.Table,
 		Projects: []string{ASTERISK},
@@ -38,17 +72,19 @@ func (p *Plan) Delete(ast *DeleteAST) error {
 	}
 	rows, err := p.Select(queryAST)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	tree := p.table.GetClusterIndex()
 	for _, row := range rows {
-		tree.Remove(row.Key)
+		if err := p.removeRow(row.Key); err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	return int64(len(rows)), nil
 }
 
-func (p *Plan) Update(ast *UpdateAST) error {
+// Update changes the rows of WHERE and returns their number
+func (p *Plan) Update(ast *UpdateAST) (int64, error) {
 	queryAST := &SelectAST{
 		Table:    ast.Table,
 		Projects: []string{ASTERISK},
@@ -57,12 +93,21 @@ func (p *Plan) Update(ast *UpdateAST) error {
 	}
 	rows, err := p.Select(queryAST)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	sets := make([]evalFunc, len(ast.NewValue))
+	for i, e := range ast.NewValue {
+		if sets[i], err = compileExpr(e, p.table.tableResolver); err != nil {
+			return 0, err
+		}
 	}
 
+	// the primary index of a table without a cluster key is kept by setRow
 	var needReInsert bool
+	clusterKey := p.table.clusterKey()
 	for _, col := range ast.Columns {
-		if col == p.table.PrimaryKey {
+		if clusterKey != "" && col == clusterKey {
 			needReInsert = true
 			break
 		}
@@ -71,168 +116,458 @@ func (p *Plan) Update(ast *UpdateAST) error {
 	if needReInsert {
 		// update字段包含主键,并且待更新的记录大于1
 		if len(rows) > 1 {
-			return fmt.Errorf("update primaryKey, row > 2")
+			return 0, fmt.Errorf("update primaryKey, row > 2")
+		}
+		if len(rows) == 0 {
+			return 0, nil
 		}
 		// 修改primaryKey的需要删除然后重新插入
-		p.reInsert(rows[0], ast)
-		return nil
+		if err := p.reInsert(rows[0], ast, sets); err != nil {
+			return 0, err
+		}
+		return 1, nil
 	}
 
 	for _, row := range rows {
-		p.update(row, ast)
+		if err := p.update(row, ast, sets); err != nil {
+			return 0, err
+		}
 	}
 
-	return nil
+	return int64(len(rows)), nil
 }
 
-func (p *Plan) update(item *BPItem, ast *UpdateAST) {
+// newVal returns a copy of the row with the SET clause applied, sets are the
+// compiled new values. The row in the tree is not modified in place so that it can be logged first
+func (p *Plan) newVal(item *BPItem, ast *UpdateAST, sets []evalFunc) ([]interface{}, error) {
+	old := item.Val.([]interface{})
+	Val := append([]interface{}{}, old...)
 	for idx1, col := range ast.Columns {
 		for idx2, c := range p.table.Columns {
-			if c == col {
-				Val := item.Val.([]interface{})
-				newVal := ast.NewValue[idx1]
-
-				v := p.table.Formatter[c](newVal)
-				Val[idx2] = v
-
-				item.Val = Val
+			if strings.ToLower(c) == col {
+				newVal, err := sets[idx1](old)
+				if err != nil {
+					return nil, err
+				}
+				if check := p.table.Constraint[c]; check != nil {
+					if err := check(newVal); err != nil {
+						return nil, fmt.Errorf("column %s. err: %s", c, err)
+					}
+				}
+				if formatter := p.table.Formatter[c]; formatter != nil {
+					newVal = formatter(newVal)
+				}
+				Val[idx2] = newVal
 				break
 			}
 		}
 	}
+	return Val, nil
 }
 
-func (p *Plan) reInsert(item *BPItem, ast *UpdateAST) {
-	newItem := &BPItem{Key: item.Key, Val: item.Key}
-	for idx1, col := range ast.Columns {
-		for idx2, c := range p.table.Columns {
-			if c == col {
-				Val := item.Val.([]interface{})
-				newVal := ast.NewValue[idx1]
-				Val[idx2] = newVal
-				newItem.Val = Val
-				break
+func (p *Plan) update(item *BPItem, ast *UpdateAST, sets []evalFunc) error {
+	Val, err := p.newVal(item, ast, sets)
+	if err != nil {
+		return err
+	}
+	return p.setRow(item.Key, Val)
+}
+
+func (p *Plan) reInsert(item *BPItem, ast *UpdateAST, sets []evalFunc) error {
+	Val, err := p.newVal(item, ast, sets)
+	if err != nil {
+		return err
+	}
+
+	var key int64
+	for idx, c := range p.table.Columns {
+		if c == p.table.clusterKey() {
+			k, ok := Val[idx].(int)
+			if !ok {
+				return fmt.Errorf("get primary key err")
 			}
+			key = int64(k)
+			break
 		}
 	}
 
 	tree := p.table.GetClusterIndex()
-	tree.Remove(item.Key)
-	update := tree.Set(newItem.Key, newItem.Val)
-	if update {
-		panic("updated!")
+	if key != item.Key && tree.Get(key) != nil {
+		return DuplicateKeyError
 	}
+	if err := p.removeRow(item.Key); err != nil {
+		return err
+	}
+	return p.setRow(key, Val)
 }
 
-func (p *Plan) Insert(dataset map[int64][]interface{}) error {
+// Insert writes all rows in order, or none of them if any key is duplicated
+func (p *Plan) Insert(dataset []*BPItem) (int64, error) {
 	tree := p.table.GetClusterIndex()
 
-	for key, data := range dataset {
-		val := tree.Get(key)
-		if val != nil {
-			return DuplicateKeyError
+	keys := make(map[int64]struct{}, len(dataset))
+	for _, row := range dataset {
+		if _, ok := keys[row.Key]; ok {
+			return 0, DuplicateKeyError // duplicated in the same VALUES list
+		}
+		if tree.Get(row.Key) != nil {
+			return 0, DuplicateKeyError
 		}
+		keys[row.Key] = struct{}{}
+	}
 
-		tree.Set(key, data)
+	for _, row := range dataset {
+		if err := p.setRow(row.Key, row.Val); err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	return int64(len(dataset)), nil
 }
 
-func (p *Plan) Select(ast *SelectAST) (ret []*BPItem, err error) {
-	// Fetch rows from storage pages
-	tree := p.table.GetClusterIndex()
-	if tree == nil {
+func (p *Plan) Select(ast *SelectAST) ([]*BPItem, error) {
+	op, err := p.Build(ast)
+	if err != nil {
+		return nil, err
+	}
+	return drain(op)
+}
+
+/*
+Build returns the operator tree of a query:
+
+	Project <- Limit <- Sort <- [Filter(HAVING) <- Aggregate] <- Filter(WHERE) <- Join ... <- Scan
+
+Sort is left out when the rows of Scan are already in the order of ORDER BY.
+*/
+func (p *Plan) Build(ast *SelectAST) (Operator, error) {
+	if p.table.GetClusterIndex() == nil {
 		return nil, TableError
 	}
 
-	i := int64(0)
-	// get all rows
-	for row := range tree.GetAllItems() {
-		// Filter rows according the ast.Where
-		if len(ast.Where) != 0 {
-			filtered, err := p.isRowFiltered(ast.Where, row)
-			if err != nil {
+	orderBy, err := p.orderByTerms(ast)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		input   Operator = &scanOp{plan: p, where: ast.Where}
+		resolve          = p.table.tableResolver
+		sorted           = len(orderBy) == 0
+		single           = len(ast.Joins) == 0 && ast.Alias == ""
+	)
+	if single {
+		// scan in the order of ORDER BY the primary key, groups are sorted after aggregating
+		if reverse, pkSorted := p.pkOrder(orderBy); pkSorted && !ast.isAggregate() {
+			input, sorted = &scanOp{plan: p, where: ast.Where, reverse: reverse}, true
+		}
+	} else {
+		// the FROM table is scanned by WHERE ranges on its own columns, which
+		// can not be ambiguous once WHERE is compiled against all the tables
+		tables, err := p.joinTables(ast)
+		if err != nil {
+			return nil, err
+		}
+		for i, join := range ast.Joins {
+			if input, err = p.join(input, tables[:i+1], tables[i+1], join); err != nil {
 				return nil, err
 			}
-			if filtered {
-				continue
-			}
 		}
+		resolve = tables.resolve
+	}
 
-		// Count row count for LIMIT clause.
-		i++
-		if i > ast.Limit && ast.Limit > 0 {
-			break
+	if ast.Where != nil {
+		filter, err := compileFilter(ast.Where, resolve)
+		if err != nil {
+			return nil, err
 		}
-		row = p.table.FilterCols(row, ast.Projects)
-		ret = append(ret, row)
+		input = &filterOp{input: input, filter: filter}
 	}
 
-	return
-}
+	fields := selectFields(ast)
+	if ast.isAggregate() {
+		if input, fields, orderBy, resolve, err = p.aggregate(ast, orderBy, input, resolve); err != nil {
+			return nil, err
+		}
+	}
 
-func (p *Plan) isRowFiltered(where []string, row *BPItem) (filtered bool, err error) {
-	var (
-		normalized = make([]string, len(where))
-		tv         types.TypeAndValue
-	)
+	if !sorted {
+		input = &sortOp{input: input, orderBy: orderBy, resolve: resolve}
+	}
+	if ast.Limit > 0 {
+		input = &limitOp{input: input, limit: ast.Limit}
+	}
 
-	var cols []string
-	for _, col := range p.table.Columns {
-		cols = append(cols, strings.ToLower(col))
+	evals := make([]evalFunc, len(fields))
+	for i, field := range fields {
+		if field == nil {
+			continue // *
+		}
+		eval, err := compileExpr(field, resolve)
+		if err != nil {
+			return nil, err
+		}
+		evals[i] = eval
 	}
+	return &projectOp{input: input, fields: evals}, nil
+}
 
-Loop:
-	for i, w := range where {
-		switch upper := strings.ToLower(w); upper {
-		case AND:
-			normalized[i] = "&&"
-			continue
-		case OR:
-			normalized[i] = "||"
+// pkOrder reports whether ORDER BY is the primary key order, forward or reverse
+func (p *Plan) pkOrder(orderBy []*OrderByTerm) (reverse bool, ok bool) {
+	if len(orderBy) == 0 {
+		return false, true
+	}
+	// the primary key is unique, the terms after it do not matter
+	ref, isRef := orderBy[0].Expr.(*ColumnRef)
+	if !isRef {
+		return false, false
+	}
+	clusterKey := p.table.clusterKey()
+	if idx, err := p.table.tableResolver(ref); err != nil || clusterKey == "" || !strings.EqualFold(p.table.Columns[idx], clusterKey) {
+		return false, false
+	}
+	return orderBy[0].Desc, true
+}
+
+// orderByTerms returns ORDER BY with the positions replaced by their projection, ORDER BY 1 is the first one
+func (p *Plan) orderByTerms(ast *SelectAST) ([]*OrderByTerm, error) {
+	var fields []Expr
+	orderBy := make([]*OrderByTerm, len(ast.OrderBy))
+	for i, term := range ast.OrderBy {
+		orderBy[i] = term
+		lit, isLiteral := term.Expr.(*Literal)
+		if !isLiteral {
 			continue
-		case "=":
-			normalized[i] = "=="
+		}
+		pos, isInt := lit.Val.(int)
+		if !isInt {
+			continue // any other constant does not change the order
+		}
+		if fields == nil {
+			var err error
+			if fields, err = p.projections(ast); err != nil {
+				return nil, err
+			}
+		}
+		if pos < 1 || pos > len(fields) {
+			return nil, fmt.Errorf("ORDER BY term %d out of range - should be between 1 and %d", pos, len(fields))
+		}
+		orderBy[i] = &OrderByTerm{Expr: fields[pos-1], Desc: term.Desc}
+	}
+	return orderBy, nil
+}
+
+// projections returns the projections of ast with * expanded to the columns of the tables
+func (p *Plan) projections(ast *SelectAST) ([]Expr, error) {
+	tables, err := p.joinTables(ast)
+	if err != nil {
+		return nil, err
+	}
+	var fields []Expr
+	for _, field := range selectFields(ast) {
+		if field != nil {
+			fields = append(fields, field)
 			continue
 		}
+		for _, jt := range tables {
+			for _, col := range jt.table.Columns {
+				ref := &ColumnRef{Name: strings.ToLower(col)}
+				if len(tables) > 1 {
+					ref.Table = jt.name
+				}
+				fields = append(fields, ref)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// selectFields returns the projections of ast, a nil field is *
+func selectFields(ast *SelectAST) []Expr {
+	if len(ast.Fields) == 0 {
+		return []Expr{nil} // not built by the parser, eg. by Delete
+	}
+	return ast.Fields
+}
+
+// sortRows sorts the rows by ORDER BY, NULL is less than any value, the sort is stable
+func sortRows(rows []*BPItem, orderBy []*OrderByTerm, resolve columnResolver) error {
+	evals := make([]evalFunc, len(orderBy))
+	for i, term := range orderBy {
+		eval, err := compileExpr(term.Expr, resolve)
+		if err != nil {
+			return err
+		}
+		evals[i] = eval
+	}
+
+	// evaluate the sort keys once per row
+	keys := make(map[*BPItem][]interface{}, len(rows))
+	for _, row := range rows {
+		key := make([]interface{}, len(evals))
+		for i, eval := range evals {
+			v, err := eval(row.Val.([]interface{}))
+			if err != nil {
+				return err
+			}
+			key[i] = v
+		}
+		keys[row] = key
+	}
 
-		for idx, col := range cols {
-			if col == strings.ToLower(w) {
-				value := row.Val.([]interface{})
-				val := value[idx]
-				rt := reflect.TypeOf(val)
-				switch rt.Kind() {
-				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-					normalized[i] = fmt.Sprintf("%d", val)
-				case reflect.Bool:
-					normalized[i] = fmt.Sprintf("%t", val)
-				case reflect.String:
-					normalized[i] = strconv.Quote(fmt.Sprintf("%s", val))
-				default:
-					normalized[i] = fmt.Sprintf("%v", val)
+	var err error
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := keys[rows[i]], keys[rows[j]]
+		for k, term := range orderBy {
+			c, ok := compareNullable(a[k], b[k])
+			if !ok {
+				if err == nil {
+					err = fmt.Errorf("ORDER BY %s: can not compare %T with %T", term.Expr, a[k], b[k])
 				}
-				continue Loop
+				return false
+			}
+			if c != 0 {
+				return (c < 0) != term.Desc
+			}
+		}
+		return false
+	})
+	return err
+}
+
+// keyRange is the range a column value must be in for a row to pass WHERE, nil bounds are unbounded
+type keyRange struct {
+	Lo, Hi         interface{}
+	LoOpen, HiOpen bool // the bound itself is excluded
+}
+
+func (r *keyRange) isPoint() bool {
+	return r.Lo != nil && r.Hi != nil && !r.LoOpen && !r.HiOpen && compareEqual(r.Lo, r.Hi)
+}
+
+func (r *keyRange) contains(v interface{}) bool {
+	if r.Lo != nil {
+		c, ok := compareValue(v, r.Lo)
+		if !ok || c < 0 || (c == 0 && r.LoOpen) {
+			return false
+		}
+	}
+	if r.Hi != nil {
+		c, ok := compareValue(v, r.Hi)
+		if !ok || c > 0 || (c == 0 && r.HiOpen) {
+			return false
+		}
+	}
+	return true
+}
+
+// keys returns the closed range of primary keys, ok is false if the range is empty
+func (r *keyRange) keys() (lo, hi int64, ok bool) {
+	lo, hi = math.MinInt64, math.MaxInt64
+	if r.Lo != nil {
+		v, isInt := r.Lo.(int)
+		if !isInt {
+			return 0, 0, false
+		}
+		lo = int64(v)
+		if r.LoOpen {
+			if lo == math.MaxInt64 {
+				return 0, 0, false
+			}
+			lo++
+		}
+	}
+	if r.Hi != nil {
+		v, isInt := r.Hi.(int)
+		if !isInt {
+			return 0, 0, false
+		}
+		hi = int64(v)
+		if r.HiOpen {
+			if hi == math.MinInt64 {
+				return 0, 0, false
 			}
+			hi--
+		}
+	}
+	return lo, hi, lo <= hi
+}
+
+func (r *keyRange) restrict(op string, v interface{}) {
+	if op == "=" || op == ">" || op == ">=" {
+		open := op == ">"
+		if c, _ := compareValue(v, r.Lo); r.Lo == nil || c > 0 || (c == 0 && open) {
+			r.Lo, r.LoOpen = v, open
+		}
+	}
+	if op == "=" || op == "<" || op == "<=" {
+		open := op == "<"
+		if c, _ := compareValue(v, r.Hi); r.Hi == nil || c < 0 || (c == 0 && open) {
+			r.Hi, r.HiOpen = v, open
 		}
+	}
+}
 
-		normalized[i] = w
+var flippedOps = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// whereRanges returns the range of every column restricted by a "col op literal"
+// term of WHERE. Only the terms joined by AND at the top of WHERE are used,
+// the filter still checks the whole WHERE on every candidate row.
+func (p *Plan) whereRanges(where Expr) map[string]*keyRange {
+	ranges := make(map[string]*keyRange)
+	where = resolveQuotedExpr(where, append([]*Table{p.table}, p.joined...)...)
+	for _, term := range conjuncts(where) {
+		col, op, val, ok := p.rangeTerm(term)
+		if !ok {
+			continue
+		}
+		if ranges[col] == nil {
+			ranges[col] = &keyRange{}
+		}
+		ranges[col].restrict(op, val)
 	}
+	return ranges
+}
 
-	expr := strings.Join(normalized, " ")
-	fSet := token.NewFileSet()
-	if tv, err = types.Eval(fSet, nil, token.NoPos, expr); err != nil {
+// rangeTerm matches "col op literal" or "literal op col"
+func (p *Plan) rangeTerm(term Expr) (col string, op string, val interface{}, ok bool) {
+	b, isBinary := term.(*BinaryExpr)
+	if !isBinary {
 		return
 	}
-	if tv.Type == nil {
-		err = fmt.Errorf("eval(%q) got nil type but no error", expr)
-		return
+	op = b.Op
+	ref, isRef := b.Left.(*ColumnRef)
+	lit, isLit := b.Right.(*Literal)
+	if !isRef || !isLit {
+		// literal op col -> col flipped(op) literal
+		ref, isRef = b.Right.(*ColumnRef)
+		lit, isLit = b.Left.(*Literal)
+		op = flippedOps[op]
 	}
-	if !strings.Contains(tv.Type.String(), "bool") {
-		err = fmt.Errorf("eval(%q) got non bool type", expr)
-		return
+	if _, isOp := flippedOps[op]; !isOp || !isRef || !isLit {
+		return "", "", nil, false
 	}
-
-	filtered = !(tv.Value.ExactString() == "true")
-	return
+	idx, err := p.table.tableResolver(ref)
+	if err != nil || lit.Val == nil {
+		return "", "", nil, false
+	}
+	val = lit.Val
+	switch v := val.(type) {
+	case int:
+		if p.table.valueType(idx) == reflect.TypeOf(0.0) {
+			val = float64(v)
+		}
+	case string:
+		if p.table.valueType(idx) == reflect.TypeOf(time.Time{}) {
+			if t, err := toTime(p.table.columnType(idx), v); err == nil {
+				val = t
+			}
+		}
+	}
+	if d, ok := toDecimal(val); ok && p.table.valueType(idx) == reflect.TypeOf(Decimal{}) {
+		val = d
+	}
+	// the range is looked up in trees keyed by values of the column type
+	if reflect.TypeOf(val) != p.table.valueType(idx) {
+		return "", "", nil, false
+	}
+	return strings.ToLower(p.table.Columns[idx]), op, val, true
 }
@@ -0,0 +1,343 @@
+package sqlite
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var NoRowError = fmt.Errorf("Scan called without calling Next")
+
+// ColumnType describes a column of a result set
+type ColumnType struct {
+	Name         string
+	DatabaseType string       // the type in CREATE TABLE, eg. INTEGER or VARCHAR(16), empty if unknown
+	ScanType     reflect.Type // the Go type of the values, interface{} if unknown
+}
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func newColumnType(name, databaseType string) *ColumnType {
+	scanType := anyType
+	switch {
+	case strings.HasPrefix(databaseType, "INTEGER"):
+		scanType = reflect.TypeOf(0)
+	case strings.HasPrefix(databaseType, "VARCHAR"), databaseType == "TEXT":
+		scanType = reflect.TypeOf("")
+	case databaseType == "REAL", databaseType == "DOUBLE":
+		scanType = reflect.TypeOf(0.0)
+	case databaseType == "BOOLEAN":
+		scanType = reflect.TypeOf(false)
+	case databaseType == "BLOB":
+		scanType = reflect.TypeOf([]byte{})
+	case databaseType == "DATE", databaseType == "TIME", databaseType == "TIMESTAMP":
+		scanType = reflect.TypeOf(time.Time{})
+	case strings.HasPrefix(databaseType, "DECIMAL"):
+		scanType = reflect.TypeOf(Decimal{})
+	}
+	return &ColumnType{Name: name, DatabaseType: databaseType, ScanType: scanType}
+}
+
+/*
+Rows is the result of a query, the values of a row are in the order of the
+projections and * is every column of every table in FROM order.
+
+	rows, err := db.Query(`SELECT id, username FROM user`)
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		err = rows.Scan(&id, &name)
+	}
+	err = rows.Err()
+
+The rows are read from the tables while Next is called.
+*/
+type Rows struct {
+	op      Operator
+	columns []*ColumnType
+	row     []interface{}
+	err     error
+	closed  bool
+}
+
+func newRows(op Operator, columns []*ColumnType) (*Rows, error) {
+	if err := op.Open(); err != nil {
+		op.Close()
+		return nil, err
+	}
+	return &Rows{op: op, columns: columns}, nil
+}
+
+// Columns returns the column names
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func (r *Rows) ColumnTypes() []*ColumnType {
+	return r.columns
+}
+
+// Next moves to the next row, it returns false and closes the rows at the end or on error
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	item, err := r.op.Next()
+	if item == nil || err != nil {
+		r.err = err
+		r.row = nil
+		if closeErr := r.Close(); r.err == nil {
+			r.err = closeErr
+		}
+		return false
+	}
+	r.row = item.Val.([]interface{})
+	return true
+}
+
+// Values returns the values of the current row, NULL is nil
+func (r *Rows) Values() []interface{} {
+	return append([]interface{}{}, r.row...)
+}
+
+/*
+Scan copies the values of the current row into dest, which are pointers. A value
+is converted to the type of its destination if no information is lost:
+
+	*interface{}             any value
+	*int, *int64, ...        INTEGER, or a float without fraction
+	*float64, *float32       INTEGER, float or DECIMAL
+	*string, *[]byte         VARCHAR, TEXT or BLOB, a number or a bool is formatted
+	*bool                    BOOLEAN
+	*time.Time               DATE, TIME or TIMESTAMP, *string gets it as ISO-8601
+	*Decimal                 DECIMAL, *string gets its digits, eg. 12.50
+	**T                      NULL sets nil, any other value is converted to T
+*/
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.row == nil {
+		return NoRowError
+	}
+	if len(dest) != len(r.row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(r.row), len(dest))
+	}
+	for i, d := range dest {
+		if err := convertAssign(d, r.row[i]); err != nil {
+			return fmt.Errorf("scan column %d %s: %s", i, r.columns[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error which ended Next
+func (r *Rows) Err() error {
+	return r.err
+}
+
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.op.Close()
+}
+
+// buffer reads all the rows in advance, the tables may change after it returns
+func (r *Rows) buffer() {
+	buf := &bufferOp{}
+	for {
+		item, err := r.op.Next()
+		if item == nil || err != nil {
+			buf.err = err
+			break
+		}
+		buf.rows = append(buf.rows, item)
+	}
+	if err := r.op.Close(); buf.err == nil {
+		buf.err = err
+	}
+	r.op = buf
+}
+
+func convertAssign(dest, src interface{}) error {
+	if d, ok := dest.(*interface{}); ok {
+		*d = src
+		return nil
+	}
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination is not a pointer: %T", dest)
+	}
+	dv = dv.Elem()
+
+	if src == nil {
+		switch dv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice:
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		return fmt.Errorf("converting NULL to %s is unsupported", dv.Type())
+	}
+	if dv.Kind() == reflect.Ptr {
+		v := reflect.New(dv.Type().Elem())
+		if err := convertAssign(v.Interface(), src); err != nil {
+			return err
+		}
+		dv.Set(v)
+		return nil
+	}
+
+	if b, ok := src.([]byte); ok {
+		src = append([]byte{}, b...) // the row must not be changed through dest
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+
+	switch dv.Kind() {
+	case reflect.String:
+		switch src := src.(type) {
+		case int:
+			dv.SetString(strconv.Itoa(src))
+			return nil
+		case float64:
+			dv.SetString(strconv.FormatFloat(src, 'g', -1, 64))
+			return nil
+		case bool:
+			dv.SetString(strconv.FormatBool(src))
+			return nil
+		case []byte:
+			dv.SetString(string(src))
+			return nil
+		case time.Time:
+			dv.SetString(formatTime(src))
+			return nil
+		case Decimal:
+			dv.SetString(src.String())
+			return nil
+		}
+	case reflect.Slice:
+		if s, ok := src.(string); ok && dv.Type().Elem().Kind() == reflect.Uint8 {
+			dv.SetBytes([]byte(s))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := integral(src); ok && !dv.OverflowInt(i) {
+			dv.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := integral(src); ok && i >= 0 && !dv.OverflowUint(uint64(i)) {
+			dv.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := toFloat(src); ok {
+			dv.SetFloat(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("converting %T %v to %s is unsupported", src, src, dv.Type())
+}
+
+// integral returns an int, or a float64 without fraction, as int64
+func integral(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case float64:
+		if i := int64(v); float64(i) == v {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Query builds the operator tree of ast and opens it
+func (p *Plan) Query(ast *SelectAST) (*Rows, error) {
+	columns, err := p.columnTypes(ast)
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.Build(ast)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(op, columns)
+}
+
+// columnTypes describes the projections of ast, a column is named by the
+// column of its table and any other expression by its text
+func (p *Plan) columnTypes(ast *SelectAST) ([]*ColumnType, error) {
+	tables, err := p.joinTables(ast)
+	if err != nil {
+		return nil, err
+	}
+	var columns []*ColumnType
+	for i, field := range selectFields(ast) {
+		if field == nil {
+			for _, jt := range tables {
+				for idx, col := range jt.table.Columns {
+					columns = append(columns, newColumnType(col, jt.table.columnType(idx)))
+				}
+			}
+			continue
+		}
+		if ref, ok := field.(*ColumnRef); ok {
+			if jt, idx, err := tables.column(ref); err == nil {
+				columns = append(columns, newColumnType(jt.table.Columns[idx], jt.table.columnType(idx)))
+				continue
+			}
+		}
+		columns = append(columns, newColumnType(ast.Projects[i], tables.exprType(field)))
+	}
+	return columns, nil
+}
+
+// column returns the table of a column and the index of the column in the table
+func (tables joinTables) column(ref *ColumnRef) (*joinTable, int, error) {
+	idx, err := tables.resolve(ref)
+	if err != nil {
+		return nil, -1, err
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if idx >= tables[i].offset {
+			return tables[i], idx - tables[i].offset, nil
+		}
+	}
+	return nil, -1, HasNotColumnError
+}
+
+// exprType returns the database type of the values of e, empty if unknown
+func (tables joinTables) exprType(e Expr) string {
+	switch e := e.(type) {
+	case *ColumnRef:
+		if jt, idx, err := tables.column(e); err == nil {
+			return jt.table.columnType(idx)
+		}
+	case *FuncCall:
+		switch {
+		case e.Name == "COUNT":
+			return "INTEGER"
+		case (e.Name == "SUM" || e.Name == "MIN" || e.Name == "MAX") && len(e.Args) == 1:
+			return tables.exprType(e.Args[0])
+		case e.Name == "DATE" || e.Name == "CURRENT_DATE":
+			return "DATE"
+		case e.Name == "TIME" || e.Name == "CURRENT_TIME":
+			return "TIME"
+		case e.Name == "DATETIME" || e.Name == "CURRENT_TIMESTAMP" || e.Name == "DATE_ADD" || e.Name == "DATE_SUB":
+			return "TIMESTAMP"
+		case e.Name == "STRFTIME":
+			return "TEXT"
+		}
+	}
+	return ""
+}
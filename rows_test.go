@@ -0,0 +1,142 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRows(t *testing.T) {
+	db := newOrderDB(t)
+
+	rows, err := db.Query(`SELECT username, u.id, amount * 2, COUNT(*) FROM user u LEFT JOIN order o ON u.id = o.user_id GROUP BY u.id, username, amount * 2 ORDER BY u.id, amount * 2 LIMIT 4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if got, want := rows.Columns(), []string{"username", "id", "amount * 2", "COUNT(*)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("columns: got %v, want %v", got, want)
+	}
+	// an expression is named by its text as written
+	named, err := db.Query(`SELECT strftime('%Y/%m/%d %H', '2024-03-01 10:00'), id + 1 FROM user`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := named.Columns(), []string{`strftime('%Y/%m/%d %H', '2024-03-01 10:00')`, "id + 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("columns: got %v, want %v", got, want)
+	}
+	named.Close()
+	var types []string
+	for _, col := range rows.ColumnTypes() {
+		types = append(types, col.DatabaseType)
+	}
+	if want := []string{"VARCHAR(16)", "INTEGER", "", "INTEGER"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("column types: got %v, want %v", types, want)
+	}
+	if typ := rows.ColumnTypes()[1].ScanType; typ != reflect.TypeOf(0) {
+		t.Errorf("scan type: got %v", typ)
+	}
+
+	var (
+		name   string
+		id     int64
+		amount *float64
+		count  interface{}
+	)
+	if err := rows.Scan(&name, &id, &amount, &count); err != NoRowError {
+		t.Errorf("expect NoRowError before Next, got %v", err)
+	}
+	type row struct {
+		name   string
+		id     int64
+		amount interface{}
+		count  interface{}
+	}
+	var got []row
+	for rows.Next() {
+		if err := rows.Scan(&name, &id, &amount, &count); err != nil {
+			t.Fatal(err)
+		}
+		r := row{name: name, id: id, count: count}
+		if amount != nil {
+			r.amount = *amount
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{
+		{"userName-1", 1, 100.0, 1},
+		{"userName-1", 1, 200.0, 1},
+		{"userName-2", 2, 140.0, 1},
+		{"userName-3", 3, nil, 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rows.Next() {
+		t.Errorf("expect no row after the end")
+	}
+}
+
+func TestRowsScan(t *testing.T) {
+	db := newOrderDB(t)
+
+	rows, err := db.Query(`SELECT * FROM user WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rows.Columns(), []string{"email", "username", "id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("columns: got %v, want %v", got, want)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var email, username []byte
+	var id string
+	if err := rows.Scan(&email, &username, &id); err != nil {
+		t.Fatal(err)
+	}
+	if string(email) != "User-1@gmail.com" || string(username) != "userName-1" || id != "1" {
+		t.Errorf("unexpected row %s %s %s", email, username, id)
+	}
+	var small int8
+	if err := rows.Scan(&email, &username, &small); err != nil || small != 1 {
+		t.Errorf("expect 1, got %d %v", small, err)
+	}
+	for _, dest := range [][]interface{}{
+		{&email, &username},            // too few
+		{&email, &username, nil},       // not a pointer
+		{&email, &username, new(bool)}, // int to bool
+		{new(int), &username, &id},     // string to int
+	} {
+		if err := rows.Scan(dest...); err == nil {
+			t.Errorf("expect error scanning into %T", dest)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Errorf("expect no row after Close")
+	}
+
+	// NULL only goes into a pointer or interface
+	rows, err = db.Query(`SELECT o.id FROM user u LEFT JOIN order o ON u.id = o.user_id WHERE u.id = 5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var n int
+	if err := rows.Scan(&n); err == nil {
+		t.Errorf("expect error scanning NULL into int")
+	}
+	p := &n
+	if err := rows.Scan(&p); err != nil || p != nil {
+		t.Errorf("expect nil, got %v %v", p, err)
+	}
+}
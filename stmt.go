@@ -0,0 +1,300 @@
+package sqlite
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+/*
+Stmt is a statement which is parsed once and executed many times. The
+parameters are ? or $n, the ? are numbered in the order they appear:
+
+	insert, err := db.Prepare(`INSERT INTO user (id, username) VALUES (?, ?)`)
+	err = insert.Exec(1, `say "hi"`)
+
+	query, err := db.Prepare(`SELECT username FROM user WHERE id = $1`)
+	rows, err := query.Query(1)
+
+The arguments are bound as typed values, they are never formatted into SQL,
+so the constraints of the columns check the values themselves. An argument
+is any int, uint or float type, string, []byte, bool or nil for NULL.
+*/
+type Stmt struct {
+	db       *DB
+	sql      string
+	Type     StatementType
+	ast      interface{} // *SelectAST, *InsertAST, *UpdateAST, ... nil for BEGIN, COMMIT and ROLLBACK
+	numInput int
+}
+
+func (db *DB) Prepare(sql string) (*Stmt, error) {
+	parser := &Parser{}
+	s := &Stmt{db: db, sql: sql, Type: parser.GetSQLType(sql)}
+	var err error
+	switch s.Type {
+	case SELECT:
+		s.ast, err = parser.ParseSelect(sql)
+	case INSERT:
+		s.ast, err = parser.ParseInsert(sql)
+	case UPDATE:
+		s.ast, err = parser.ParseUpdate(sql)
+	case DELETE:
+		s.ast, err = parser.ParseDelete(sql)
+	case CREATE:
+		s.ast, err = parser.ParseCreateTable(sql)
+	case INDEX:
+		s.ast, err = parser.ParseCreateIndex(sql)
+	case DROP:
+		s.ast, err = parser.ParseDropTable(sql)
+	case TRUNCATE:
+		s.ast, err = parser.ParseTruncate(sql)
+	case ALTER:
+		s.ast, err = parser.ParseAlterTable(sql)
+	case BEGIN, COMMIT, ROLLBACK:
+	default:
+		return nil, parser.unsupported(sql)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapExprs(s.ast, func(e Expr) (Expr, error) {
+		walkExpr(e, func(e Expr) {
+			if p, ok := e.(*Param); ok && p.Index >= s.numInput {
+				s.numInput = p.Index + 1
+			}
+		})
+		return e, nil
+	})
+	return s, nil
+}
+
+// NumInput returns the number of arguments of the statement
+func (s *Stmt) NumInput() int {
+	return s.numInput
+}
+
+// Exec runs the statement like DB.Exec
+func (s *Stmt) Exec(args ...interface{}) error {
+	_, err := s.execute(args)
+	return err
+}
+
+// execute runs the statement like Exec, it returns the number of rows changed by the statement
+func (s *Stmt) execute(args []interface{}) (int64, error) {
+	switch s.Type {
+	case BEGIN, COMMIT, ROLLBACK:
+		return 0, TxStatementError
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	affected, err := tx.exec(s, args)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return affected, tx.Commit()
+}
+
+// Query runs a SELECT like DB.Query
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	ast, err := s.bindSelect(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.readQuery(ast)
+}
+
+// bindSelect binds the arguments of a SELECT
+func (s *Stmt) bindSelect(args []interface{}) (*SelectAST, error) {
+	if s.Type != SELECT {
+		return nil, fmt.Errorf("is not select sql")
+	}
+	ast, err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return ast.(*SelectAST), nil
+}
+
+// exec applies the statement to the tables, the transaction is up to the caller
+func (s *Stmt) exec(args []interface{}) (int64, error) {
+	ast, err := s.bind(args)
+	if err != nil {
+		return 0, err
+	}
+	switch ast := ast.(type) {
+	case *InsertAST:
+		return s.db.insert(ast)
+	case *UpdateAST:
+		return s.db.update(ast)
+	case *DeleteAST:
+		return s.db.delete(ast)
+	case *CreateTableAST:
+		return 0, s.db.createTable(ast, s.sql)
+	case *CreateIndexAST:
+		return 0, s.db.createIndex(ast, s.sql)
+	case *DropTableAST:
+		return 0, s.db.dropTable(ast, s.sql)
+	case *TruncateAST:
+		return 0, s.db.truncateTable(ast, s.sql)
+	case *AlterTableAST:
+		return 0, s.db.alterTable(ast, s.sql)
+	}
+	return 0, fmt.Errorf("unsuported sql")
+}
+
+// bind returns a copy of the AST where the parameters are replaced by the arguments
+func (s *Stmt) bind(args []interface{}) (interface{}, error) {
+	if len(args) != s.numInput {
+		return nil, fmt.Errorf("expect %d arguments, got %d", s.numInput, len(args))
+	}
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		v, err := bindValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument $%d: %s", i+1, err)
+		}
+		values[i] = v
+	}
+
+	return mapExprs(s.ast, func(e Expr) (Expr, error) {
+		return rewriteExpr(e, func(e Expr) (Expr, error) {
+			if p, ok := e.(*Param); ok {
+				return &Literal{Val: values[p.Index]}, nil
+			}
+			return nil, nil
+		})
+	})
+}
+
+// bindValue converts an argument to the types of Literal
+func bindValue(arg interface{}) (interface{}, error) {
+	switch arg := arg.(type) {
+	case nil, int, float64, string, bool:
+		return arg, nil
+	case time.Time:
+		return arg.UTC(), nil
+	case Decimal:
+		return arg, nil
+	case []byte:
+		return append([]byte{}, arg...), nil // a BLOB, the caller may reuse the slice
+	}
+
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt {
+			return nil, fmt.Errorf("%d overflows int", v.Uint())
+		}
+		return int(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return bindValue(v.Elem().Interface())
+	}
+	return nil, fmt.Errorf("unsupported type %T", arg)
+}
+
+// mapExprs returns a copy of an AST where every expression e is replaced by fn(e)
+func mapExprs(ast interface{}, fn func(e Expr) (Expr, error)) (interface{}, error) {
+	var err error
+	apply := func(e Expr) Expr {
+		if e == nil || err != nil {
+			return e
+		}
+		var mapped Expr
+		mapped, err = fn(e)
+		return mapped
+	}
+
+	switch ast := ast.(type) {
+	case *SelectAST:
+		c := *ast
+		c.Fields = make([]Expr, len(ast.Fields))
+		for i, field := range ast.Fields {
+			c.Fields[i] = apply(field)
+		}
+		c.Joins = make([]*JoinClause, len(ast.Joins))
+		for i, join := range ast.Joins {
+			j := *join
+			j.On = apply(join.On)
+			c.Joins[i] = &j
+		}
+		c.Where = apply(ast.Where)
+		c.GroupBy = make([]Expr, len(ast.GroupBy))
+		for i, e := range ast.GroupBy {
+			c.GroupBy[i] = apply(e)
+		}
+		c.Having = apply(ast.Having)
+		c.OrderBy = make([]*OrderByTerm, len(ast.OrderBy))
+		for i, term := range ast.OrderBy {
+			c.OrderBy[i] = &OrderByTerm{Expr: apply(term.Expr), Desc: term.Desc}
+		}
+		return &c, err
+	case *InsertAST:
+		c := *ast
+		c.Columns = append([]string{}, ast.Columns...)
+		c.Values = make([][]Expr, len(ast.Values))
+		for i, row := range ast.Values {
+			c.Values[i] = make([]Expr, len(row))
+			for j, e := range row {
+				c.Values[i][j] = apply(e)
+			}
+		}
+		return &c, err
+	case *UpdateAST:
+		c := *ast
+		c.Columns = append([]string{}, ast.Columns...)
+		c.NewValue = make([]Expr, len(ast.NewValue))
+		for i, e := range ast.NewValue {
+			c.NewValue[i] = apply(e)
+		}
+		c.Where = apply(ast.Where)
+		return &c, err
+	case *DeleteAST:
+		c := *ast
+		c.Where = apply(ast.Where)
+		return &c, err
+	}
+	return ast, nil // no expressions
+}
+
+// resolveQuoted returns a copy of an AST where every bare "name" is the column
+// if one of the tables has it, and the string otherwise, as SQLite does
+func resolveQuoted(ast interface{}, tables ...*Table) (interface{}, error) {
+	return mapExprs(ast, func(e Expr) (Expr, error) {
+		return resolveQuotedExpr(e, tables...), nil
+	})
+}
+
+// resolveQuotedExpr resolves the bare "name" of an expression like resolveQuoted
+func resolveQuotedExpr(e Expr, tables ...*Table) Expr {
+	e, _ = rewriteExpr(e, func(e Expr) (Expr, error) {
+		ref, ok := e.(*ColumnRef)
+		if !ok || ref.Quoted == nil {
+			return nil, nil
+		}
+		for _, t := range tables {
+			if _, err := t.tableResolver(&ColumnRef{Name: ref.Name}); err == nil {
+				return &ColumnRef{Name: ref.Name}, nil
+			}
+		}
+		return ref.Quoted, nil
+	})
+	return e
+}
@@ -0,0 +1,177 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStmt(t *testing.T) {
+	db := NewDB()
+	if err := db.Exec(createUserSQL); err != nil {
+		t.Fatal(err)
+	}
+
+	insert, err := db.Prepare(`INSERT INTO user (id, username, email) VALUES (?, ?, ?), (? + 10, $2, "b@x.com")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := insert.NumInput(); n != 4 {
+		t.Errorf("expect 4 inputs, got %d", n)
+	}
+	names := []string{`say "hi"`, `it's`, `a, b) --`}
+	for i, name := range names {
+		if err := insert.Exec(i+1, name, "a@x.com", int64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query, err := db.Prepare(`SELECT username FROM user WHERE id > $1 AND id <= $1 + ? ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := query.NumInput(); n != 2 {
+		t.Errorf("expect 2 inputs, got %d", n)
+	}
+	scan := func(rows *Rows, err error) []string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, name)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := scan(query.Query(0, 3)); !reflect.DeepEqual(got, names) {
+		t.Errorf("got %q, want %q", got, names)
+	}
+	if got := scan(query.Query(uint8(10), 2.0)); !reflect.DeepEqual(got, names[:2]) {
+		t.Errorf("got %q, want %q", got, names[:2])
+	}
+
+	update, err := db.Prepare(`UPDATE user SET username = ?, email = username WHERE id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := update.Exec([]byte("bytes"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(db.Query(`SELECT email FROM user WHERE id = 1`)); !reflect.DeepEqual(got, []string{`say "hi"`}) {
+		t.Errorf("SET uses the old row: got %q", got)
+	}
+	if err := db.Exec(`UPDATE user SET id = id + 100 WHERE id = 11`); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(db.Query(`SELECT username FROM user WHERE id > 100`)); !reflect.DeepEqual(got, names[:1]) {
+		t.Errorf("got %q, want %q", got, names[:1])
+	}
+
+	del, err := db.Prepare(`DELETE FROM user WHERE id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Exec(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(db.Query(`SELECT username FROM user WHERE id < 10 ORDER BY id`)); !reflect.DeepEqual(got, []string{"bytes", names[2]}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestStmtBlob(t *testing.T) {
+	db := NewDB()
+	if err := db.Exec(`CREATE TABLE file (id INTEGER, data BLOB, PRIMARY KEY (id))`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE INDEX idx_data ON file (data)`); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare(`INSERT INTO file (id, data) VALUES (?, ?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := []byte{0x0a, 0xff}
+	if err := insert.Exec(1, buf); err != nil {
+		t.Fatal(err)
+	}
+	buf[0] = 0x0b // the argument is copied, the row keeps 0a
+	if err := insert.Exec(2, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := db.Prepare(`SELECT id, data FROM file WHERE data = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, data := range map[int][]byte{1: {0x0a, 0xff}, 2: {0x0b, 0xff}} {
+		rows, err := query.Query(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]interface{}
+		for rows.Next() {
+			got = append(got, rows.Values())
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if want := [][]interface{}{{id, data}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("X'%x': expect %v, got %v", data, want, got)
+		}
+	}
+}
+
+func TestStmtError(t *testing.T) {
+	db := NewDB()
+	newUserDB(t, db, 1)
+
+	insert, err := db.Prepare(`INSERT INTO user (id, username) VALUES (?, ?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{
+		{2},                      // too few arguments
+		{2, "a", 3},              // too many arguments
+		{"2", "a"},               // string into INTEGER
+		{2, 1},                   // integer into VARCHAR
+		{2, "abcdefghijklmnopq"}, // longer than VARCHAR(16)
+		{2, struct{}{}},          // unsupported type
+		{uint64(1) << 63, "a"},   // overflows int
+		{1, "a"},                 // duplicate primary key
+	} {
+		if err := insert.Exec(args...); err == nil {
+			t.Errorf("%v: expect error", args)
+		}
+	}
+
+	// the failed statements changed nothing
+	var count int
+	rows, err := db.Query(`SELECT COUNT(*) FROM user`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() || rows.Scan(&count) != nil || count != 1 {
+		t.Errorf("expect 1 row, got %d", count)
+	}
+	rows.Close()
+
+	if _, err := db.Query(`SELECT id FROM user WHERE id = ?`); err == nil {
+		t.Errorf("expect error for unbound parameter")
+	}
+	if err := db.Exec(`DELETE FROM user WHERE id = $1`); err == nil {
+		t.Errorf("expect error for unbound parameter")
+	}
+	if err := db.Exec(`INSERT INTO user (id, username) VALUES (id, "a")`); err == nil {
+		t.Errorf("expect error for column in VALUES")
+	}
+	if _, err := insert.Query(1, "a"); err == nil {
+		t.Errorf("expect error for Query of INSERT")
+	}
+}
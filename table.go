@@ -1,75 +1,124 @@
 package sqlite
 
 import (
+	"fmt"
+	"math"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // Table get table from .frm file
 type Table struct {
 	Name         string
-	PrimaryKey   string
+	PrimaryKey   string // the column of a single column primary key, see primaryKey
 	Columns      []string
-	Constraint   map[string]func(data string) error
-	Formatter    map[string]func(data string) interface{}
+	Constraint   map[string]func(v interface{}) error // checks a typed value, see Compose
+	Formatter    map[string]func(v interface{}) interface{}
 	DefaultValue []interface{}
-	Indies       map[string]*BPTree // multi indies, maybe
+	Indies       map[string]*BPTree // "-" is the clustered index, the others are secondary indexes
+	Schema       *CreateTableAST    // used to save and reload the table
+	IndexSchema  map[string]*CreateIndexAST
 }
 
 func (t *Table) GetClusterIndex() *BPTree {
 	return t.Indies["-"]
 }
 
-// map[primaryKeyValue]rowData
-// NOTE: 简单实现,限死prmaryKey必须是数字类型
-func (t *Table) Format(ast *InsertAST) map[int64][]interface{} {
-	vals := make([][]interface{}, 0, len(ast.Values))
-	for rowIdx, row := range ast.Values {
-		if len(row) > len(t.Formatter) {
-			panic("len(row) > len(t.formatter)")
-		}
+// primaryKey returns the columns of the primary key, a table built without a Schema has PrimaryKey only
+func (t *Table) primaryKey() []string {
+	if t.Schema != nil {
+		return t.Schema.PrimaryKey
+	}
+	if t.PrimaryKey != "" {
+		return []string{t.PrimaryKey}
+	}
+	return nil
+}
 
-		vals = append(vals, make([]interface{}, len(ast.Columns)))
+/*
+clusterKey returns the primary key if it is a single INTEGER column, the
+clustered index is then keyed by its value. The rows of any other table are
+keyed by a rowid in insertion order, and the primary key is a unique index,
+see primaryIndex.
+*/
+func (t *Table) clusterKey() string {
+	if t.Schema == nil || t.PrimaryKey == "" {
+		return t.PrimaryKey
+	}
+	if !strings.HasPrefix(t.columnType(t.columnIdx(t.PrimaryKey)), "INTEGER") {
+		return ""
+	}
+	return t.PrimaryKey
+}
 
-		for colIdx, colData := range row {
-			colName := ast.Columns[colIdx]
-			if t.Formatter[colName] == nil {
-				panic("t.formatter[idx] == nil")
-			}
-			data := t.Formatter[colName](colData)
-			vals[rowIdx][colIdx] = data
-		}
+// nextRowID returns the key after the last row of the clustered index
+func (t *Table) nextRowID() int64 {
+	c := t.GetClusterIndex().NewCursor(true)
+	if c.SeekTo(math.MaxInt64) {
+		return c.Key() + 1
 	}
+	return 1
+}
 
-	var fullColVals [][]interface{}
-	for rowIdx := range ast.Values {
-		data := t.fullZeroValue(ast.Columns, vals[rowIdx])
-		fullColVals = append(fullColVals, data)
+// Format returns the rows of ast in statement order, keyed by primary key value or by a new rowid
+func (t *Table) Format(ast *InsertAST) ([]*BPItem, error) {
+	res := make([]*BPItem, 0, len(ast.Values))
+	clusterKey := t.clusterKey()
+	var rowID int64
+	if clusterKey == "" {
+		rowID = t.nextRowID()
 	}
+	for _, row := range ast.Values {
+		vals := make([]interface{}, len(row))
+		for colIdx, e := range row {
+			v, err := constValue(e)
+			if err != nil {
+				return nil, err
+			}
+			if formatter := t.Formatter[t.Columns[t.columnIdx(ast.Columns[colIdx])]]; formatter != nil {
+				v = formatter(v)
+			}
+			vals[colIdx] = v
+		}
 
-	res := make(map[int64][]interface{})
-	for _, rowVals := range fullColVals {
+		rowVals := t.fullZeroValue(ast.Columns, vals)
+		if clusterKey == "" {
+			res = append(res, &BPItem{Key: rowID, Val: rowVals})
+			rowID++
+			continue
+		}
 		for colIdx, val := range rowVals {
-			if t.Columns[colIdx] == t.PrimaryKey {
+			if t.Columns[colIdx] == clusterKey {
 				k, ok := val.(int)
 				if !ok {
-					panic("get primary key err")
+					return nil, fmt.Errorf("get primary key err")
 				}
-				key := int64(k)
-				res[key] = rowVals
+				res = append(res, &BPItem{Key: int64(k), Val: rowVals})
 				break
 			}
 		}
 	}
+	return res, nil
+}
 
-	return res
+// constValue evaluates a value of VALUES, which can not read any column
+func constValue(e Expr) (interface{}, error) {
+	eval, err := compileExpr(e, func(col *ColumnRef) (int, error) {
+		return -1, fmt.Errorf("can not use column %s in VALUES", col)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return eval(nil)
 }
 
 func (t *Table) fullZeroValue(astCols []string, astVal []interface{}) []interface{} {
 	var data []interface{}
 	for idx, col := range t.Columns {
-		newVal := t.DefaultValue[idx]
+		newVal := t.defaultValue(idx)
 		for astIdx, astCol := range astCols {
-			if astCol == col {
+			if strings.EqualFold(astCol, col) {
 				newVal = astVal[astIdx]
 				break
 			}
@@ -79,6 +128,61 @@ func (t *Table) fullZeroValue(astCols []string, astVal []interface{}) []interfac
 	return data
 }
 
+// defaultValue returns the DEFAULT of a column, CURRENT_TIMESTAMP is the time it is called
+func (t *Table) defaultValue(idx int) interface{} {
+	if c, ok := t.DefaultValue[idx].(currentTime); ok {
+		return c.now()
+	}
+	return t.DefaultValue[idx]
+}
+
+// columnType returns the type of a column in CREATE TABLE, eg. INTEGER or VARCHAR(16)
+func (t *Table) columnType(idx int) string {
+	if t.Schema != nil && idx < len(t.Schema.Type) {
+		return t.Schema.Type[idx]
+	}
+	switch v := t.DefaultValue[idx].(type) {
+	case int:
+		return "INTEGER"
+	case string:
+		return "VARCHAR"
+	case float64:
+		return "REAL"
+	case bool:
+		return "BOOLEAN"
+	case []byte:
+		return "BLOB"
+	case time.Time:
+		return "TIMESTAMP"
+	case currentTime:
+		return v.column
+	case Decimal:
+		return fmt.Sprintf("DECIMAL(%d,%d)", maxPrecision, v.scale)
+	}
+	return ""
+}
+
+// valueType returns the Go type of the values of a column, nil if it is unknown
+func (t *Table) valueType(idx int) reflect.Type {
+	switch t := t.columnType(idx); {
+	case strings.HasPrefix(t, "INTEGER"):
+		return reflect.TypeOf(0)
+	case strings.HasPrefix(t, "VARCHAR"), t == "TEXT":
+		return reflect.TypeOf("")
+	case t == "REAL", t == "DOUBLE":
+		return reflect.TypeOf(0.0)
+	case t == "BOOLEAN":
+		return reflect.TypeOf(false)
+	case t == "BLOB":
+		return reflect.TypeOf([]byte{})
+	case t == "DATE", t == "TIME", t == "TIMESTAMP":
+		return reflect.TypeOf(time.Time{})
+	case strings.HasPrefix(t, "DECIMAL"):
+		return reflect.TypeOf(Decimal{})
+	}
+	return nil
+}
+
 func (t *Table) FilterCols(item *BPItem, cols []string) *BPItem {
 	if len(cols) == 1 && cols[0] == ASTERISK {
 		return item
@@ -102,12 +206,12 @@ func (t *Table) CheckSelectConstraint(ast *SelectAST) *ConstraintError {
 		return err
 	}
 
-	cols := make(map[string]struct{}, len(t.Columns))
-	for _, c := range t.Columns {
-		cols[strings.ToLower(c)] = struct{}{}
+	if len(ast.Joins) != 0 || ast.Alias != "" {
+		// the columns are checked when they are resolved against all the tables
+		return t.CheckLimit(ast.Limit)
 	}
 
-	for _, p := range ast.Projects {
+	for idx, p := range ast.Projects {
 		if p == ASTERISK {
 			if len(ast.Projects) != 1 {
 				return &ConstraintError{Table: t.Name, Err: SyntaxError}
@@ -115,8 +219,8 @@ func (t *Table) CheckSelectConstraint(ast *SelectAST) *ConstraintError {
 			break
 		}
 
-		if _, ok := cols[p]; !ok {
-			return &ConstraintError{Table: t.Name, Err: HasNotColumnError}
+		if err := t.checkExpr(ast.Fields[idx]); err != nil {
+			return err
 		}
 	}
 
@@ -124,6 +228,20 @@ func (t *Table) CheckSelectConstraint(ast *SelectAST) *ConstraintError {
 		return err
 	}
 
+	for _, e := range ast.GroupBy {
+		if err := t.checkExpr(e); err != nil {
+			return err
+		}
+	}
+
+	if err := t.checkExpr(ast.Having); err != nil {
+		return err
+	}
+
+	if err := t.CheckOrderBy(ast.OrderBy); err != nil {
+		return err
+	}
+
 	if err := t.CheckLimit(ast.Limit); err != nil {
 		return err
 	}
@@ -150,52 +268,83 @@ func (t *Table) CheckInsertConstraint(ast *InsertAST) *ConstraintError {
 		ast.Columns[idx] = strings.ToLower(col)
 	}
 
-	for _, row := range ast.Values {
-		if len(row) > len(t.Constraint) {
-			panic("len(row) > len(t.Constraint)")
+	if len(ast.Columns) == 0 {
+		// INSERT INTO table VALUES (...) sets all columns in order
+		for _, col := range t.Columns {
+			ast.Columns = append(ast.Columns, strings.ToLower(col))
 		}
+	}
+	for _, col := range ast.Columns {
+		if _, err := t.tableResolver(&ColumnRef{Name: col}); err != nil {
+			return &ConstraintError{Table: t.Name, Column: col, Err: err}
+		}
+	}
 
-		var primaryKeyIdx = -1
+	primaryKey := t.primaryKey()
+	if len(primaryKey) == 0 {
+		return &ConstraintError{Table: t.Name, Err: HasNoPrimaryKeyError}
+	}
+	for _, col := range primaryKey {
+		if !containsColumn(ast.Columns, strings.ToLower(col)) {
+			return &ConstraintError{Table: t.Name, Column: col, Err: HasNoPrimaryKeyError}
+		}
+	}
+
+	for _, row := range ast.Values {
+		if len(row) != len(ast.Columns) {
+			return &ConstraintError{Table: t.Name, Row: row, Err: fmt.Errorf("expect %d values, got %d", len(ast.Columns), len(row))}
+		}
 
-		for idx, colData := range row {
-			colName := ast.Columns[idx]
+		for idx, e := range row {
+			colName := t.Columns[t.columnIdx(ast.Columns[idx])] // as in CREATE TABLE, which keys Constraint
 
-			// NOTE: 简单实现, 限死primary只能是一个col
-			if colName == t.PrimaryKey {
-				primaryKeyIdx = idx
+			v, err := constValue(e)
+			if err != nil {
+				return &ConstraintError{Table: t.Name, Row: row, Column: colName, Err: err}
 			}
-
 			if t.Constraint[colName] == nil {
 				continue
 			}
-			if err := t.Constraint[colName](colData); err != nil {
-				return &ConstraintError{Table: t.Name, Row: row, Column: t.Columns[idx], Err: err}
+			if err := t.Constraint[colName](v); err != nil {
+				return &ConstraintError{Table: t.Name, Row: row, Column: colName, Err: err}
 			}
 		}
+	}
 
-		if primaryKeyIdx == -1 {
-			return &ConstraintError{Table: t.Name, Row: row, Column: t.Columns[primaryKeyIdx], Err: HasNoPrimaryKeyError}
+	// the columns which are not set get their default, eg. NULL into a NOT NULL column
+	for idx, col := range t.Columns {
+		if t.Constraint[col] == nil || containsColumn(ast.Columns, strings.ToLower(col)) {
+			continue
+		}
+		if err := t.Constraint[col](t.defaultValue(idx)); err != nil {
+			return &ConstraintError{Table: t.Name, Column: col, Err: err}
 		}
 	}
 	return nil
 }
 
-func (t *Table) CheckUpdateConstraint(ast *UpdateAST) *ConstraintError {
-	if len(ast.NewValue) > len(t.Constraint) {
-		panic("len(ast.NewValue) > len(t.Constraint)")
+func containsColumn(columns []string, col string) bool {
+	for _, c := range columns {
+		if c == col {
+			return true
+		}
 	}
+	return false
+}
 
+// CheckUpdateConstraint checks the columns of SET, the new values are checked
+// against the constraints of their column when they are evaluated for each row
+func (t *Table) CheckUpdateConstraint(ast *UpdateAST) *ConstraintError {
 	for idx, col := range ast.Columns {
 		ast.Columns[idx] = strings.ToLower(col)
+		if _, err := t.tableResolver(&ColumnRef{Name: ast.Columns[idx]}); err != nil {
+			return &ConstraintError{Table: t.Name, Column: col, Err: err}
+		}
 	}
 
-	for idx, newVal := range ast.NewValue {
-		colName := ast.Columns[idx]
-		if t.Constraint[colName] == nil {
-			continue
-		}
-		if err := t.Constraint[colName](newVal); err != nil {
-			return &ConstraintError{Table: t.Name, Column: t.Columns[idx], Err: err}
+	for _, e := range ast.NewValue {
+		if err := t.checkExpr(e); err != nil {
+			return err
 		}
 	}
 
@@ -210,27 +359,29 @@ func (t *Table) CheckUpdateConstraint(ast *UpdateAST) *ConstraintError {
 	return nil
 }
 
-func (t *Table) CheckWhere(where []string) *ConstraintError {
-	cols := make(map[string]struct{}, len(t.Columns))
-	for _, c := range t.Columns {
-		cols[strings.ToLower(c)] = struct{}{}
-	}
+func (t *Table) CheckWhere(where Expr) *ConstraintError {
+	return t.checkExpr(where)
+}
 
-	needCheck := true
-	for _, w := range where {
-		if w == ";" {
-			continue
-		}
-		if needCheck {
-			if _, ok := cols[w]; !ok {
-				return &ConstraintError{Table: t.Name, Err: HasNotColumnError}
-			}
-			needCheck = false
+func (t *Table) CheckOrderBy(orderBy []*OrderByTerm) *ConstraintError {
+	for _, term := range orderBy {
+		if err := t.checkExpr(term.Expr); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if w == AND || w == OR {
-			needCheck = true
+// checkExpr checks that every column in the expression exists
+func (t *Table) checkExpr(expr Expr) *ConstraintError {
+	var err error
+	walkExpr(expr, func(e Expr) {
+		if col, ok := e.(*ColumnRef); ok && err == nil {
+			_, err = t.tableResolver(col)
 		}
+	})
+	if err != nil {
+		return &ConstraintError{Table: t.Name, Err: err}
 	}
 	return nil
 }
@@ -243,7 +394,7 @@ func (t *Table) CheckLimit(limit int64) *ConstraintError {
 }
 
 func (t *Table) CheckTable(table string) *ConstraintError {
-	if table != t.Name {
+	if !strings.EqualFold(table, t.Name) {
 		return &ConstraintError{Table: t.Name, Err: TableError}
 	}
 	return nil
@@ -251,7 +402,7 @@ func (t *Table) CheckTable(table string) *ConstraintError {
 
 type ConstraintError struct {
 	Table  string
-	Row    []string
+	Row    []Expr
 	Column string
 	Err    error
 }
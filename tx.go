@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	"fmt"
+)
+
+var (
+	TxDoneError       = fmt.Errorf("transaction has already been committed or rolled back")
+	TxInProgressError = fmt.Errorf("transaction already in progress")
+	NoTxError         = fmt.Errorf("no transaction in progress")
+	TxStatementError  = fmt.Errorf("BEGIN, COMMIT and ROLLBACK run on a Conn, use DB.Conn or DB.Begin")
+)
+
+/*
+Tx groups statements atomically. There is one writer at a time: Begin
+blocks until the previous transaction and the running queries of DB.Query
+are finished, so a goroutine must not call DB.Exec or DB.Query while its
+own Tx is open, use Tx.Exec and Tx.Query instead. The queries outside of
+the transaction wait for it, they never see its uncommitted changes.
+
+Every row change remembers the prior value of the row in an undo log.
+A failed statement undoes its own changes, Rollback undoes all of them.
+Statements executed by DB.Exec outside a transaction run in their own Tx,
+BEGIN on a Conn starts a Tx for the statements of the Conn.
+*/
+type Tx struct {
+	db   *DB
+	undo []func() error
+	done bool
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	db.txLock.Lock()
+	tx := &Tx{db: db}
+	db.current = tx
+	return tx, nil
+}
+
+func (tx *Tx) Exec(sql string) error {
+	_, err := tx.execute(sql)
+	return err
+}
+
+// execute runs a statement like Exec, it returns the number of rows changed by the statement
+func (tx *Tx) execute(sql string) (int64, error) {
+	stmt, err := tx.db.Prepare(sql)
+	if err != nil {
+		return 0, err
+	}
+	return tx.run(stmt, nil)
+}
+
+// run runs a prepared statement in the transaction
+func (tx *Tx) run(s *Stmt, args []interface{}) (int64, error) {
+	if tx.done {
+		return 0, TxDoneError
+	}
+	switch s.Type {
+	case BEGIN:
+		return 0, TxInProgressError
+	case COMMIT:
+		return 0, tx.Commit()
+	case ROLLBACK:
+		return 0, tx.Rollback()
+	default:
+		return tx.exec(s, args)
+	}
+}
+
+// Query runs a SELECT in the transaction, it sees the changes of the transaction
+func (tx *Tx) Query(sql string) (*Rows, error) {
+	stmt, err := tx.db.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	return tx.query(stmt, nil)
+}
+
+// query runs a prepared SELECT in the transaction, which holds txLock already
+func (tx *Tx) query(s *Stmt, args []interface{}) (*Rows, error) {
+	if tx.done {
+		return nil, TxDoneError
+	}
+	ast, err := s.bindSelect(args)
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.query(ast)
+}
+
+// exec runs one statement, the changes of a failed statement are undone
+func (tx *Tx) exec(s *Stmt, args []interface{}) (int64, error) {
+	mark := len(tx.undo)
+	affected, err := s.exec(args)
+	if err != nil {
+		if undoErr := tx.rollbackTo(mark); undoErr != nil {
+			return 0, fmt.Errorf("%s, undo err: %s", err, undoErr)
+		}
+		return 0, err
+	}
+	return affected, nil
+}
+
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return TxDoneError
+	}
+	defer tx.finish()
+
+	if tx.db.wal == nil {
+		return nil
+	}
+	if err := tx.db.wal.commit(); err != nil {
+		return err
+	}
+	if tx.db.wal.size > tx.db.CheckpointSize {
+		return tx.db.checkpoint()
+	}
+	return nil
+}
+
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return TxDoneError
+	}
+	defer tx.finish()
+
+	err := tx.rollbackTo(0)
+	if tx.db.wal != nil {
+		if abortErr := tx.db.wal.abort(); err == nil {
+			err = abortErr
+		}
+	}
+	return err
+}
+
+func (tx *Tx) finish() {
+	tx.done = true
+	tx.undo = nil
+	tx.db.current = nil
+	tx.db.txLock.Unlock()
+}
+
+// rollbackTo undoes the changes made after the undo log had mark entries
+func (tx *Tx) rollbackTo(mark int) error {
+	for i := len(tx.undo) - 1; i >= mark; i-- {
+		if err := tx.undo[i](); err != nil {
+			return err
+		}
+	}
+	tx.undo = tx.undo[:mark]
+	return nil
+}
+
+// rowUndo restores the row with key to the value it has now
+func (tx *Tx) rowUndo(table *Table, key int64) func() error {
+	old := table.GetClusterIndex().Get(key)
+	return func() error {
+		// the undo is logged too, so that a replay of the log ends up in the same state
+		plan := NewPlan(table)
+		if tx.db.wal != nil {
+			plan.journal = tx.db.wal
+		}
+		if old == nil {
+			return plan.removeRow(key)
+		}
+		return plan.setRow(key, old)
+	}
+}
+
+func (tx *Tx) put(table *Table, key int64, val interface{}) error {
+	undo := tx.rowUndo(table, key)
+	if tx.db.wal != nil {
+		if err := tx.db.wal.put(table, key, val); err != nil {
+			return err
+		}
+	}
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+func (tx *Tx) remove(table *Table, key int64) error {
+	undo := tx.rowUndo(table, key)
+	if tx.db.wal != nil {
+		if err := tx.db.wal.remove(table, key); err != nil {
+			return err
+		}
+	}
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+func (tx *Tx) ddl(sql string, undo func()) error {
+	if tx.db.wal != nil {
+		if err := tx.db.wal.ddl(sql, undo); err != nil {
+			return err
+		}
+	}
+	tx.undo = append(tx.undo, func() error { undo(); return nil })
+	return nil
+}
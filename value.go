@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+)
+
+// compareValue compares two column values, ok is false if they are not comparable
+func compareValue(a, b interface{}) (result int, ok bool) {
+	switch x := a.(type) {
+	case int:
+		y, ok := b.(int)
+		if !ok {
+			if _, isFloat := b.(float64); isFloat {
+				return compareValue(float64(x), b)
+			}
+			if y, isDecimal := b.(Decimal); isDecimal {
+				return -y.Cmp(Decimal{unscaled: big.NewInt(int64(x))}), true
+			}
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case float64:
+		var y float64
+		switch v := b.(type) {
+		case float64:
+			y = v
+		case int:
+			y = float64(v)
+		case Decimal:
+			if c, ok := compareValue(v, x); ok {
+				return -c, true
+			}
+			return 0, false
+		default:
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			if t, isTime := b.(time.Time); isTime {
+				c, ok := compareTime(t, x)
+				return -c, ok
+			}
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case y:
+			return -1, true
+		}
+		return 1, true
+	case []byte:
+		y, ok := b.([]byte)
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(x, y), true
+	case time.Time:
+		return compareTime(x, b)
+	case Decimal:
+		y, ok := toDecimal(b)
+		if !ok {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case []interface{}:
+		// the values of a composite key, in order
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return 0, false
+		}
+		for i := range x {
+			if c, ok := compareValue(x[i], y[i]); !ok || c != 0 {
+				return c, ok
+			}
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func compareEqual(a, b interface{}) bool {
+	result, ok := compareValue(a, b)
+	return ok && result == 0
+}
+
+// compareNullable is compareValue where NULL is equal to NULL and less than any value
+func compareNullable(a, b interface{}) (result int, ok bool) {
+	switch {
+	case a == nil && b == nil:
+		return 0, true
+	case a == nil:
+		return -1, true
+	case b == nil:
+		return 1, true
+	}
+	return compareValue(a, b)
+}
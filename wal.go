@@ -0,0 +1,244 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+/*
+The write-ahead log lives next to the database file (path + "-wal").
+It starts with a header holding the generation of the database file it
+belongs to, followed by records:
+
+	[payload length uint32][crc32 of payload uint32][payload]
+
+Every change a statement makes is logged before it touches the tree,
+and the statement ends with a commit or an abort record. On Open the
+committed statements are replayed, a torn or uncommitted tail is ignored.
+Checkpoint writes the database file with the next generation and starts
+an empty log, so a log left over from an older generation is stale.
+*/
+
+const defaultCheckpointSize = 1 << 20
+
+var walMagic = []byte("SQLTOYWAL\x00\x01")
+
+const (
+	walPut byte = iota + 1
+	walRemove
+	walExec
+	walCommit
+	walAbort
+)
+
+type walRecord struct {
+	Type  byte
+	Table string
+	Key   int64
+	Val   interface{}
+	SQL   string
+}
+
+type wal struct {
+	file    *os.File
+	size    int64
+	pending bool // there are records not followed by commit/abort yet
+}
+
+func walPath(path string) string {
+	return path + "-wal"
+}
+
+// createWAL opens the log at path and resets it
+func createWAL(path string, generation uint64) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{file: f}
+	if err := w.reset(generation); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// reset empties the log, the database file of the given generation holds all changes
+func (w *wal) reset(generation uint64) error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	header := binary.BigEndian.AppendUint64(append([]byte{}, walMagic...), generation)
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	w.size = int64(len(header))
+	return w.file.Sync()
+}
+
+// readWAL returns the committed records of the log belonging to the given generation
+func readWAL(path string, generation uint64) ([]*walRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(walMagic) + 8
+	if len(data) < headerSize || !bytes.Equal(data[:len(walMagic)], walMagic) {
+		return nil, nil // crashed before the header was synced
+	}
+	if binary.BigEndian.Uint64(data[len(walMagic):]) != generation {
+		return nil, nil // already checkpointed into the database file
+	}
+
+	var committed, pending []*walRecord
+	data = data[headerSize:]
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		sum := binary.BigEndian.Uint32(data[4:])
+		if uint64(len(data)-8) < uint64(size) {
+			break // torn write
+		}
+		payload := data[8 : 8+size]
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		data = data[8+size:]
+
+		record, err := decodeWALRecord(payload)
+		if err != nil {
+			break
+		}
+		switch record.Type {
+		case walCommit:
+			committed = append(committed, pending...)
+			pending = pending[:0]
+		case walAbort:
+			pending = pending[:0]
+		default:
+			pending = append(pending, record)
+		}
+	}
+	return committed, nil
+}
+
+func decodeWALRecord(payload []byte) (*walRecord, error) {
+	d := &decoder{buf: payload}
+	record := &walRecord{Type: d.byte()}
+	switch record.Type {
+	case walPut:
+		record.Table = d.string()
+		record.Key = d.varint()
+		record.Val = d.value()
+	case walRemove:
+		record.Table = d.string()
+		record.Key = d.varint()
+	case walExec:
+		record.SQL = d.string()
+	case walCommit, walAbort:
+	default:
+		return nil, fmt.Errorf("unknown wal record %d", record.Type)
+	}
+	return record, d.err
+}
+
+func (w *wal) append(payload []byte) error {
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	buf = append(buf, payload...)
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	return nil
+}
+
+func (w *wal) put(table *Table, key int64, val interface{}) error {
+	payload := appendBytes([]byte{walPut}, []byte(table.Name))
+	payload = binary.AppendVarint(payload, key)
+	payload, err := appendValue(payload, val)
+	if err != nil {
+		return err
+	}
+	w.pending = true
+	return w.append(payload)
+}
+
+func (w *wal) remove(table *Table, key int64) error {
+	payload := appendBytes([]byte{walRemove}, []byte(table.Name))
+	payload = binary.AppendVarint(payload, key)
+	w.pending = true
+	return w.append(payload)
+}
+
+// ddl logs a statement which is replayed by executing it again, eg. CREATE TABLE.
+// The undo is owned by the Tx journal, the log only skips an aborted statement.
+func (w *wal) ddl(sql string, _ func()) error {
+	w.pending = true
+	return w.append(appendBytes([]byte{walExec}, []byte(sql)))
+}
+
+// commit makes the logged changes of the statement durable
+func (w *wal) commit() error {
+	if !w.pending {
+		return nil
+	}
+	w.pending = false
+	if err := w.append([]byte{walCommit}); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// abort tells the replay to skip the changes logged since the last commit
+func (w *wal) abort() error {
+	if !w.pending {
+		return nil
+	}
+	w.pending = false
+	return w.append([]byte{walAbort})
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+// replay applies committed records, the log itself is not written meanwhile
+func (db *DB) replay(records []*walRecord) error {
+	for _, record := range records {
+		switch record.Type {
+		case walExec:
+			stmt, err := db.Prepare(record.SQL)
+			if err == nil {
+				_, err = stmt.exec(nil)
+			}
+			if err != nil {
+				return fmt.Errorf("replay %q err: %s", record.SQL, err)
+			}
+		case walPut, walRemove:
+			table := db.GetTable(record.Table)
+			if table == nil {
+				return fmt.Errorf("replay err: has no such table: %s", record.Table)
+			}
+			plan := NewPlan(table)
+			var err error
+			if record.Type == walPut {
+				err = plan.setRow(record.Key, record.Val)
+			} else {
+				err = plan.removeRow(record.Key)
+			}
+			if err != nil {
+				return fmt.Errorf("replay %s key %d err: %s", record.Table, record.Key, err)
+			}
+		}
+	}
+	return nil
+}
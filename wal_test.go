@@ -0,0 +1,203 @@
+package sqlite
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// walStatement is the i-th statement of the workload used by the crash tests
+func walStatement(i int) string {
+	switch {
+	case i == 0:
+		return createUserSQL
+	case i%5 == 0:
+		return fmt.Sprintf(`UPDATE user SET username = "updated-%d" WHERE id = %d`, i, i-1)
+	case i%7 == 0:
+		return fmt.Sprintf(`DELETE FROM user WHERE id = %d`, i-3)
+	default:
+		return fmt.Sprintf(`INSERT INTO user (id, username, email) VALUES (%d, "userName-%d", "User-%d@gmail.com")`, i, i, i)
+	}
+}
+
+func snapshot(db *DB) map[int64]interface{} {
+	table := db.GetTable("user")
+	if table == nil {
+		return nil
+	}
+	data := make(map[int64]interface{})
+	for item := range table.GetClusterIndex().GetAllItems() {
+		data[item.Key] = item.Val
+	}
+	return data
+}
+
+// checkCommittedPrefix fails unless got equals the state after some prefix of the workload
+func checkCommittedPrefix(t *testing.T, got map[int64]interface{}, maxStatements int) {
+	if got == nil {
+		return // crashed before CREATE TABLE was committed
+	}
+	db := NewDB()
+	for i := 0; i < maxStatements; i++ {
+		if err := db.Exec(walStatement(i)); err != nil {
+			t.Fatal(err)
+		}
+		if want := snapshot(db); len(want) == len(got) && reflect.DeepEqual(want, got) {
+			return
+		}
+	}
+	t.Fatalf("recovered %d rows which are not a committed prefix", len(got))
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CheckpointSize = 1 << 40
+
+	const statements = 300
+	offsets := make([]int64, 0, statements)
+	states := make([]map[int64]interface{}, 0, statements)
+	for i := 0; i < statements; i++ {
+		if err := db.Exec(walStatement(i)); err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, db.wal.size)
+		states = append(states, snapshot(db))
+	}
+	log, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the log at random points as if the writer was killed while appending
+	for round := 0; round < 50; round++ {
+		cut := rand.Int63n(int64(len(log)) + 1)
+		crashed := filepath.Join(dir, fmt.Sprintf("crash-%d.db", round))
+		if err := os.WriteFile(walPath(crashed), log[:cut], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		recovered, err := Open(crashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want map[int64]interface{}
+		for idx, offset := range offsets {
+			if offset <= cut {
+				want = states[idx]
+			}
+		}
+		if got := snapshot(recovered); !reflect.DeepEqual(got, want) {
+			t.Fatalf("cut at %d: recovered %d rows, want %d", cut, len(got), len(want))
+		}
+		if err := recovered.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// copyWAL copies the log of the database as if the process crashed, and opens it
+func copyWAL(t *testing.T, db *DB, path string) (*DB, error) {
+	log, err := os.ReadFile(walPath(db.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(walPath(path), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Open(path)
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CheckpointSize = 1 << 40
+	newUserDB(t, db, 3)
+	for _, sql := range []string{
+		`CREATE UNIQUE INDEX idx_username ON user (username)`,
+		`ALTER TABLE user ADD COLUMN created TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
+	} {
+		if err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+	}
+
+	// the rows keep the time of ALTER, not the time of the replay
+	want := snapshot(db)
+	time.Sleep(10 * time.Millisecond)
+	recovered, err := copyWAL(t, db, filepath.Join(dir, "alter.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(recovered); !reflect.DeepEqual(got, want) {
+		t.Errorf("expect %v, got %v", want, got)
+	}
+	recovered.Close()
+
+	// a committed row which breaks the unique index fails the recovery
+	if err := db.wal.put(db.GetTable("user"), 100, want[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.wal.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered, err := copyWAL(t, db, filepath.Join(dir, "duplicate.db")); err == nil {
+		recovered.Close()
+		t.Errorf("expect the duplicate username fails the replay")
+	}
+	db.Close()
+}
+
+func TestWALCrashWriter(t *testing.T) {
+	// the writer stops after statements, so a failed check replays no more than that
+	const statements = 1 << 14
+	if path := os.Getenv("SQLITE_TOY_CRASH_WRITER"); path != "" {
+		// child process: write until killed or done
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.CheckpointSize = 16 << 10
+		for i := 0; i < statements; i++ {
+			if err := db.Exec(walStatement(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+
+	for round := 0; round < 5; round++ {
+		path := filepath.Join(t.TempDir(), "test.db")
+		cmd := exec.Command(os.Args[0], "-test.run=^TestWALCrashWriter$")
+		cmd.Env = append(os.Environ(), "SQLITE_TOY_CRASH_WRITER="+path)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(50+rand.Intn(200)) * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := snapshot(db)
+		t.Logf("recovered %d rows", len(got))
+		checkCommittedPrefix(t, got, statements)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}